@@ -2,24 +2,30 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 
 	"github.com/tonto/gourmet/internal/config"
+	"github.com/tonto/gourmet/internal/platform/acme"
 	"github.com/tonto/gourmet/internal/platform/ingress"
-	"github.com/tonto/kit/http"
+	"github.com/tonto/gourmet/internal/platform/server"
 	"github.com/tonto/kit/http/middleware"
 )
 
 func main() {
 	configFile := flag.String("config", "/etc/gourmet/gourmet.toml", "path to configuration file")
 	logFile := flag.String("log", "/var/log/gourmet/access.log", "path to log file")
+	test := flag.Bool("t", false, "test configuration file and exit")
+	adminPort := flag.Int("admin-port", 0, "localhost port of admin endpoint reloading config on POST /reload, disabled if 0")
 	flag.Parse()
 
-	r, err := os.Open(*configFile)
-	checkErr(err)
+	if *test {
+		os.Exit(testConfig(*configFile))
+	}
 
-	cfg, err := config.Parse(r)
+	cfg, err := config.ParseFile(*configFile)
 	checkErr(err)
 
 	err = os.MkdirAll("/var/log/gourmet", 0766)
@@ -35,24 +41,91 @@ func main() {
 
 	// TODO - Handle startup / gracefull shutdown better
 	// eg. coordinate stop() with server shutdown
-	// move server from kit to gourmet?
-	stop := run(ig, cfg)
+	listeners, stop, err := runStreams(cfg, logger)
+	checkErr(err)
 	defer stop()
 
-	sv := http.NewServer(
-		http.WithHandler(ig),
-		http.WithLogger(logger),
-		http.WithMiddleware(
-			middleware.CORS(),
-		),
-	)
+	stopLocs, err := runLocations(ig, cfg, logger)
+	checkErr(err)
+
+	rl := reloader{
+		file:   *configFile,
+		ig:     ig,
+		logger: logger,
+		cfg:    cfg,
+		stop:   stopLocs,
+	}
+	defer rl.close()
+
+	if cfg.Server != nil {
+		opts := []server.Option{server.WithLogger(logger)}
+
+		if t := cfg.Server.TLS; t != nil {
+			tc, src, err := getTLSConfig(t, logger)
+			checkErr(err)
+			opts = append(opts, server.WithTLS(tc))
+
+			c := make(chan struct{})
+			defer close(c)
+
+			if src.store != nil {
+				go src.store.Watch(t.ReloadInterval.Duration, c)
+				rl.certs = src.store
+			}
+
+			var redirect http.Handler = server.RedirectHandler(cfg.Server.Port)
+
+			if src.acme != nil {
+				go src.acme.Run(c)
 
-	err = sv.Run(cfg.Server.Port)
+				// http-01 challenges are answered on both listeners
+				ig.RegisterACMEHandler(src.acme)
+				mux := http.NewServeMux()
+				mux.Handle(acme.ChallengePath, src.acme)
+				mux.Handle("/", redirect)
+				redirect = mux
+			}
+
+			if t.RedirectPort != 0 {
+				listeners = append(listeners, listener{
+					port:    t.RedirectPort,
+					service: server.New(redirect, server.WithLogger(logger)),
+				})
+			}
+		}
+
+		sv := server.New(middleware.Adapt(ig, middleware.CORS()), opts...)
+		listeners = append([]listener{{port: cfg.Server.Port, service: sv}}, listeners...)
+	}
+
+	if *adminPort != 0 {
+		listeners = append(listeners, listener{
+			host:    "127.0.0.1",
+			port:    *adminPort,
+			service: server.New(&rl, server.WithLogger(logger)),
+		})
+	}
+
+	err = serve(listeners, logger, func() { rl.reload() })
 	if err != nil {
 		logger.Println("error stopping server", err)
 	}
 }
 
+// testConfig reports config file problems
+// returning exit status like nginx -t
+func testConfig(file string) int {
+	_, err := config.ParseFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintf(os.Stderr, "configuration file %s test failed\n", file)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "configuration file %s test is successful\n", file)
+	return 0
+}
+
 func checkErr(err error) {
 	if err != nil {
 		log.Fatal(err)
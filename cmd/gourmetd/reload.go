@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/tonto/gourmet/internal/config"
+	"github.com/tonto/gourmet/internal/platform/certs"
+	"github.com/tonto/gourmet/internal/platform/ingress"
+)
+
+// drainTimeout bounds the time replaced upstream servers are
+// kept running for requests still in flight eg. websockets
+const drainTimeout = 30 * time.Second
+
+var errReloadServer = errors.New("adding or removing server block requires a restart")
+
+// reloader rebuilds server locations and their upstreams
+// from config file and swaps them into the ingress without
+// dropping requests in flight
+type reloader struct {
+	file   string
+	ig     *ingress.Ingress
+	certs  *certs.Store
+	logger *log.Logger
+
+	m    sync.Mutex
+	cfg  *config.Config
+	stop func()
+}
+
+// reload reparses config file and swaps in new locations,
+// current config is kept running if it fails. TLS certificates
+// are reloaded regardless of the config result.
+func (rl *reloader) reload() error {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+
+	var errs []error
+
+	if err := rl.reloadConfig(); err != nil {
+		rl.logger.Printf("error reloading config, keeping current one: %v", err)
+		errs = append(errs, err)
+	}
+
+	if rl.certs != nil {
+		if err := rl.certs.Reload(); err != nil {
+			rl.logger.Printf("error reloading tls certificates: %v", err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (rl *reloader) reloadConfig() error {
+	cfg, err := config.ParseFile(rl.file)
+	if err != nil {
+		return err
+	}
+
+	if (cfg.Server == nil) != (rl.cfg.Server == nil) {
+		return errReloadServer
+	}
+
+	for _, c := range restartRequired(rl.cfg, cfg) {
+		rl.logger.Printf("%s changed, the change is applied on restart", c)
+	}
+
+	next := ingress.New(rl.logger)
+	stop, err := runLocations(next, cfg, rl.logger)
+	if err != nil {
+		return err
+	}
+
+	drained := rl.ig.Swap(next)
+
+	go func(stop func()) {
+		select {
+		case <-drained:
+		case <-time.After(drainTimeout):
+			rl.logger.Printf("requests still in flight after %s, stopping replaced upstream servers", drainTimeout)
+		}
+		stop()
+	}(rl.stop)
+
+	rl.cfg, rl.stop = cfg, stop
+
+	rl.logger.Printf("Reloaded config %s", rl.file)
+
+	return nil
+}
+
+// close stops upstream servers of current locations
+func (rl *reloader) close() {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+	rl.stop()
+}
+
+// ServeHTTP implements admin endpoint,
+// config is reloaded on POST /reload
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/reload" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rl.logger.Printf("Reloading (requested by %s)...", r.RemoteAddr)
+
+	err := rl.reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintln(w, "OK")
+}
+
+// restartRequired lists changes between prev and next config
+// which are not applied by reload: server listeners and
+// streams along with upstreams they pass to
+func restartRequired(prev, next *config.Config) []string {
+	var changes []string
+
+	if prev.Server != nil && next.Server != nil {
+		if prev.Server.Port != next.Server.Port {
+			changes = append(changes, "server port")
+		}
+		if !reflect.DeepEqual(listenerTLS(prev.Server.TLS), listenerTLS(next.Server.TLS)) {
+			changes = append(changes, "server tls")
+		}
+	}
+
+	if !reflect.DeepEqual(prev.Streams, next.Streams) {
+		changes = append(changes, "streams")
+	}
+
+	seen := make(map[string]bool)
+	for _, st := range prev.Streams {
+		names := []string{st.TCPPass, st.UDPPass}
+		for _, r := range st.Routes {
+			names = append(names, r.TCPPass)
+		}
+		for _, n := range names {
+			if n == "" || seen[n] {
+				continue
+			}
+			seen[n] = true
+			if !reflect.DeepEqual(prev.Upstreams[n], next.Upstreams[n]) {
+				changes = append(changes, fmt.Sprintf("upstream %q used by streams", n))
+			}
+		}
+	}
+
+	return changes
+}
+
+// listenerTLS returns t without the settings
+// applied to locations, which are reloaded
+func listenerTLS(t *config.TLS) *config.TLS {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	c.ClientCertHeaders = nil
+	return &c
+}
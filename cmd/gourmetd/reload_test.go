@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/config"
+	"github.com/tonto/gourmet/internal/platform/ingress"
+)
+
+const serverConfig = `
+[upstreams]
+    [upstreams.backend]
+        [[upstreams.backend.servers]]
+            path="%s"
+
+[server]
+    port=8080
+
+    [[server.locations]]
+        path="/"
+        http_pass="backend"
+`
+
+const streamConfig = `
+[upstreams]
+    [upstreams.db]
+        [[upstreams.db.servers]]
+            path="127.0.0.1:5432"
+
+[[streams]]
+    port=5432
+    tcp_pass="db"
+`
+
+func TestReload(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer up.Close()
+
+	cases := map[string]struct {
+		next    string
+		wantErr error
+	}{
+		"parse failure": {
+			next: "[server",
+		},
+		"server removed": {
+			next:    streamConfig,
+			wantErr: errReloadServer,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "gourmet.toml")
+			writeConfig(t, file, fmt.Sprintf(serverConfig, up.URL))
+
+			cfg, err := config.ParseFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			logger := log.New(ioutil.Discard, "", 0)
+			ig := ingress.New(logger)
+			stop, err := runLocations(ig, cfg, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rl := reloader{file: file, ig: ig, logger: logger, cfg: cfg, stop: stop}
+			defer rl.close()
+
+			writeConfig(t, file, c.next)
+
+			err = rl.reload()
+			assert.NotNil(t, err)
+			if c.wantErr != nil {
+				assert.True(t, errors.Is(err, c.wantErr), "%v", err)
+			}
+
+			// current config keeps serving requests
+			assert.True(t, cfg == rl.cfg)
+
+			w := httptest.NewRecorder()
+			ig.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "ok", w.Body.String())
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	base := func() *config.Config {
+		return &config.Config{
+			Upstreams: map[string]*config.Upstream{
+				"backend": {Balancer: "round_robin"},
+				"db":      {Balancer: "round_robin"},
+			},
+			Server: &config.Server{
+				Port: 443,
+				TLS:  &config.TLS{CertFile: "cert.pem", KeyFile: "key.pem"},
+			},
+			Streams: []*config.Stream{{Port: 5432, TCPPass: "db"}},
+		}
+	}
+
+	cases := map[string]struct {
+		change func(*config.Config)
+		want   []string
+	}{
+		"no changes": {
+			change: func(*config.Config) {},
+		},
+		"server port": {
+			change: func(c *config.Config) { c.Server.Port = 8443 },
+			want:   []string{"server port"},
+		},
+		"server tls": {
+			change: func(c *config.Config) { c.Server.TLS.CertFile = "other.pem" },
+			want:   []string{"server tls"},
+		},
+		"client cert headers": {
+			change: func(c *config.Config) {
+				c.Server.TLS.ClientCertHeaders = &config.ClientCertHeaders{Subject: "X-Client-Subject"}
+			},
+		},
+		"streams": {
+			change: func(c *config.Config) { c.Streams[0].Port = 5433 },
+			want:   []string{"streams"},
+		},
+		"stream upstream": {
+			change: func(c *config.Config) { c.Upstreams["db"].Balancer = "random" },
+			want:   []string{`upstream "db" used by streams`},
+		},
+		"location upstream": {
+			change: func(c *config.Config) { c.Upstreams["backend"].Balancer = "random" },
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			next := base()
+			c.change(next)
+			assert.Equal(t, c.want, restartRequired(base(), next))
+		})
+	}
+}
+
+func writeConfig(t *testing.T, file, data string) {
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
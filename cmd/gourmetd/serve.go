@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// listener represents a service accepting connections on a port
+// of host, or of all interfaces if host is empty
+type listener struct {
+	host    string
+	port    int
+	service service
+}
+
+type service interface {
+	Stop() error
+}
+
+// streamService is served on a tcp listener
+type streamService interface {
+	Serve(net.Listener) error
+}
+
+// packetService is served on a udp packet connection
+type packetService interface {
+	ServePacket(net.PacketConn) error
+}
+
+// serve runs all listeners and blocks until one of them
+// fails or SIGINT/SIGTERM is received in which case all
+// of them are gracefully stopped. On SIGHUP reload is called.
+func serve(listeners []listener, logger *log.Logger, reload func()) error {
+	var started []listener
+
+	errc := make(chan error, len(listeners))
+
+	for _, l := range listeners {
+		host := l.host
+		if host == "" {
+			host = "0.0.0.0"
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(l.port))
+
+		switch s := l.service.(type) {
+		case packetService:
+			pc, err := net.ListenPacket("udp", addr)
+			if err != nil {
+				stopAll(started)
+				return err
+			}
+			go func() { errc <- s.ServePacket(pc) }()
+		case streamService:
+			nl, err := net.Listen("tcp", addr)
+			if err != nil {
+				stopAll(started)
+				return err
+			}
+			go func() { errc <- s.Serve(nl) }()
+		}
+
+		started = append(started, l)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	var err error
+
+loop:
+	for {
+		select {
+		case err = <-errc:
+			break loop
+		case s := <-sig:
+			if s != syscall.SIGHUP {
+				break loop
+			}
+			logger.Println("Reloading...")
+			reload()
+		}
+	}
+
+	logger.Println("Server shutting down...")
+
+	if serr := stopAll(started); err == nil {
+		err = serr
+	}
+
+	logger.Println("Server stopped.")
+
+	return err
+}
+
+func stopAll(listeners []listener) error {
+	var wg sync.WaitGroup
+
+	errc := make(chan error, len(listeners))
+
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(s service) {
+			defer wg.Done()
+			errc <- s.Stop()
+		}(l.service)
+	}
+
+	wg.Wait()
+	close(errc)
+
+	for err := range errc {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
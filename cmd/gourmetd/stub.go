@@ -1,72 +1,408 @@
 package main
 
 import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
 	"time"
 
 	"github.com/tonto/gourmet/internal/platform/ingress"
 
 	"github.com/tonto/gourmet/internal/balancer"
 	"github.com/tonto/gourmet/internal/config"
+	"github.com/tonto/gourmet/internal/health"
+	"github.com/tonto/gourmet/internal/platform/acme"
+	"github.com/tonto/gourmet/internal/platform/certs"
 	"github.com/tonto/gourmet/internal/platform/protocol"
+	"github.com/tonto/gourmet/internal/platform/stream"
+	"github.com/tonto/gourmet/internal/provider"
 	"github.com/tonto/gourmet/internal/upstream"
 )
 
-func run(ig *ingress.Ingress, cfg *config.Config) func() {
-	m := make(map[string]balancer.Balancer)
-	qc := []chan struct{}{}
+// pool represents upstream servers shared by all
+// locations and streams passing to the same upstream
+type pool struct {
+	servers      []*upstream.Server
+	balancer     balancer.Balancer
+	transport    http.RoundTripper
+	h2cTransport http.RoundTripper
+}
 
-	for _, loc := range cfg.Server.Locations {
-		ups := cfg.Upstreams[loc.HTTPPass]
-		servers := getServers(ups)
-		for _, s := range servers {
-			c := make(chan struct{})
-			qc = append(qc, c)
-			go s.Run(c)
+// pools creates upstream server pools on first use
+// and runs their servers until stopped
+type pools struct {
+	upstreams map[string]*config.Upstream
+	pools     map[string]*pool
+	qc        []chan struct{}
+
+	// err is the first error creating a pool
+	err error
+}
+
+func newPools(upstreams map[string]*config.Upstream) *pools {
+	return &pools{
+		upstreams: upstreams,
+		pools:     make(map[string]*pool),
+	}
+}
+
+// get returns pool of upstream name, on error the
+// returned pool is empty and the error is kept in err
+func (ps *pools) get(name string) *pool {
+	if p, ok := ps.pools[name]; ok {
+		return p
+	}
+
+	ups := ps.upstreams[name]
+
+	var p pool
+
+	servers, err := getServers(ups)
+	if err == nil {
+		p.servers = servers
+		p.balancer, err = balancer.New(ups.Balancer, servers)
+	}
+	if err != nil {
+		if ps.err == nil {
+			ps.err = fmt.Errorf("upstream %s: %w", name, err)
 		}
-		bl := getBalancer(ups.Balancer, servers)
-		m[loc.Path] = bl
+		return &p
 	}
 
-	for path, h := range m {
-		// TODO - determine type of protocol by looking at Protocol in location list
-		ig.RegisterLocHandler(path, protocol.NewHTTP(h))
+	for _, s := range servers {
+		c := make(chan struct{})
+		ps.qc = append(ps.qc, c)
+		go s.Run(c)
 	}
 
-	return func() {
-		for _, c := range qc {
-			c <- struct{}{}
+	ps.pools[name] = &p
+	return &p
+}
+
+func (ps *pools) stop() {
+	for _, c := range ps.qc {
+		c <- struct{}{}
+	}
+	for _, p := range ps.pools {
+		for _, t := range []http.RoundTripper{p.transport, p.h2cTransport} {
+			if t, ok := t.(*http.Transport); ok {
+				t.CloseIdleConnections()
+			}
 		}
 	}
 }
 
-func getBalancer(alg string, s []*upstream.Server) balancer.Balancer {
-	switch alg {
-	case config.RoundRobinAlg:
-		return balancer.NewRoundRobin(s)
-	case config.RandomAlg:
-		return balancer.NewRandom(s)
+// runLocations registers server locations with ig, returned
+// func stops upstream servers the locations pass to
+func runLocations(ig *ingress.Ingress, cfg *config.Config, logger *log.Logger) (func(), error) {
+	ps := newPools(cfg.Upstreams)
+
+	if cfg.Server == nil {
+		return ps.stop, nil
+	}
+
+	for _, loc := range cfg.Server.Locations {
+		ups := cfg.Upstreams[loc.Pass()]
+		p := ps.get(loc.Pass())
+
+		opts := []protocol.HTTPOption{protocol.WithHTTPScheme(ups.Scheme)}
+		if ups.HashKey != "" {
+			opts = append(opts, protocol.WithHTTPHashKey(ups.HashKey))
+		}
+		if ups.NextUpstream {
+			opts = append(opts, protocol.WithHTTPNextUpstream(len(p.servers)))
+		}
+		if t := cfg.Server.TLS; t != nil && t.ClientCertHeaders != nil {
+			opts = append(opts, protocol.WithHTTPClientCertHeaders(protocol.ClientCertHeaders{
+				Subject:     t.ClientCertHeaders.Subject,
+				SANs:        t.ClientCertHeaders.SANs,
+				Fingerprint: t.ClientCertHeaders.Fingerprint,
+			}))
+		}
+
+		var ph ingress.ProtocolHandler
+
+		switch {
+		case loc.FastCGIPass != "":
+			ph = getFastCGI(&loc, ups, p, logger)
+		case loc.GRPCPass != "":
+			if p.h2cTransport == nil {
+				t := getTransport(&ups.Transport)
+				t.Protocols = new(http.Protocols)
+				if ups.Scheme == config.HTTPSScheme {
+					t.Protocols.SetHTTP2(true)
+				} else {
+					t.Protocols.SetUnencryptedHTTP2(true)
+				}
+				p.h2cTransport = t
+			}
+			opts = append(opts, protocol.WithHTTPTransport(p.h2cTransport))
+			ph = protocol.NewGRPC(p.balancer, opts...)
+		default:
+			if p.transport == nil {
+				p.transport = getTransport(&ups.Transport)
+			}
+			opts = append(opts, protocol.WithHTTPTransport(p.transport))
+			ph = protocol.NewHTTP(p.balancer, opts...)
+		}
+
+		locOpts := []ingress.LocOption{
+			ingress.WithFlushInterval(loc.FlushInterval.Duration),
+			ingress.WithIdleTimeout(loc.IdleTimeout.Duration),
+		}
+		if loc.ClientCert {
+			locOpts = append(locOpts, ingress.WithClientCert())
+		}
+
+		ig.RegisterLocHandler(loc.Path, ph, locOpts...)
+	}
+
+	if ps.err != nil {
+		ps.stop()
+		return nil, ps.err
 	}
-	return nil
+
+	return ps.stop, nil
 }
 
-func getServers(ups *config.Upstream) []*upstream.Server {
-	var servers []*upstream.Server
-
-	switch ups.Provider {
-	case config.StaticProvider:
-		for _, s := range ups.Servers {
-			servers = append(
-				servers,
-				upstream.NewServer(
-					s.Path,
-					upstream.WithWeight(s.Weight),
-					upstream.WithFailTimeout(time.Duration(s.FailTimeout)*time.Second),
-					upstream.WithMaxFail(s.MaxFail),
-					upstream.WithQueueSize(100),
+// runStreams creates stream listeners, returned func
+// stops upstream servers the streams pass to
+//
+// Streams are not reloaded so they use upstream servers
+// of their own, separate from the ones used by locations.
+func runStreams(cfg *config.Config, logger *log.Logger) ([]listener, func(), error) {
+	ps := newPools(cfg.Upstreams)
+
+	var streams []listener
+
+	for _, st := range cfg.Streams {
+		if st.UDPPass != "" {
+			streams = append(streams, listener{
+				port: st.Port,
+				service: stream.NewUDP(
+					ps.get(st.UDPPass).balancer,
+					stream.WithUDPIdleTimeout(st.IdleTimeout.Duration),
+					stream.WithUDPLogger(logger),
 				),
-			)
+			})
+			continue
 		}
+
+		opts := []stream.TCPOption{
+			stream.WithTCPConnectTimeout(st.ConnectTimeout.Duration),
+			stream.WithTCPIdleTimeout(st.IdleTimeout.Duration),
+			stream.WithTCPLogger(logger),
+		}
+
+		if len(st.Routes) > 0 {
+			var routes []stream.Route
+			for _, r := range st.Routes {
+				routes = append(routes, stream.Route{
+					ServerName: r.ServerName,
+					ALPN:       r.ALPN,
+					Balancer:   ps.get(r.TCPPass).balancer,
+				})
+			}
+			opts = append(opts, stream.WithTCPTLSRoutes(routes...))
+		}
+
+		// tls passthrough streams may have no default upstream
+		var bl balancer.Balancer
+		if st.TCPPass != "" {
+			p := ps.get(st.TCPPass)
+			bl = p.balancer
+			if cfg.Upstreams[st.TCPPass].NextUpstream {
+				opts = append(opts, stream.WithTCPNextUpstream(len(p.servers)))
+			}
+		}
+
+		streams = append(streams, listener{
+			port:    st.Port,
+			service: stream.NewTCP(bl, opts...),
+		})
+	}
+
+	if ps.err != nil {
+		ps.stop()
+		return nil, nil, ps.err
 	}
 
-	return servers
+	return streams, ps.stop, nil
+}
+
+func getFastCGI(loc *config.ServerLocation, ups *config.Upstream, p *pool, logger *log.Logger) *protocol.FastCGI {
+	fc := loc.FastCGI
+
+	opts := []protocol.FastCGIOption{
+		protocol.WithFastCGIRoot(fc.Root),
+		protocol.WithFastCGIIndex(fc.Index),
+		// split path is validated by config.Parse
+		protocol.WithFastCGISplitPath(regexp.MustCompile(fc.SplitPath)),
+		protocol.WithFastCGIParams(fc.Params),
+		protocol.WithFastCGIStderr(logger.Writer()),
+		protocol.WithFastCGIConnectTimeout(fc.ConnectTimeout.Duration),
+		protocol.WithFastCGIReadTimeout(fc.ReadTimeout.Duration),
+	}
+	if ups.HashKey != "" {
+		opts = append(opts, protocol.WithFastCGIHashKey(ups.HashKey))
+	}
+	if ups.NextUpstream {
+		opts = append(opts, protocol.WithFastCGINextUpstream(len(p.servers)))
+	}
+
+	return protocol.NewFastCGI(p.balancer, opts...)
+}
+
+// tlsSources represents certificate sources of server tls config
+type tlsSources struct {
+	store *certs.Store
+	acme  *acme.Manager
+}
+
+func getTLSConfig(t *config.TLS, logger *log.Logger) (*tls.Config, *tlsSources, error) {
+	var src tlsSources
+
+	tc := tls.Config{
+		MinVersion:   t.Version(),
+		CipherSuites: t.CipherSuites(),
+		ClientAuth:   t.ClientAuthType(),
+	}
+
+	if t.ClientCAFile != "" {
+		pool, err := t.ClientCertPool()
+		if err != nil {
+			return nil, nil, err
+		}
+		tc.ClientCAs = pool
+	}
+
+	if pairs := t.Pairs(); len(pairs) > 0 {
+		var cp []certs.Pair
+		for _, p := range pairs {
+			cp = append(cp, certs.Pair{CertFile: p.CertFile, KeyFile: p.KeyFile})
+		}
+
+		store, err := certs.New(cp, certs.WithLogger(logger))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		src.store = store
+		tc.GetCertificate = store.GetCertificate
+	}
+
+	if a := t.ACME; a != nil {
+		opts := []acme.Option{
+			acme.WithEmail(a.Email),
+			acme.WithDirectoryURL(a.DirectoryURL),
+			acme.WithStorageDir(a.StorageDir),
+			acme.WithChallenges(a.Challenges...),
+			acme.WithRenewBefore(a.RenewBefore.Duration),
+			acme.WithLogger(logger),
+		}
+		if src.store != nil {
+			// names other than acme domains are served from files
+			opts = append(opts, acme.WithFallback(src.store.GetCertificate))
+		}
+
+		m, err := acme.New(a.Domains, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		src.acme = m
+		tc.GetCertificate = m.GetCertificate
+		tc.NextProtos = []string{acme.ALPNProto}
+
+		if tc.ClientAuth != tls.NoClientCert {
+			// CA validation requests present no client certificate
+			alpn := tc.Clone()
+			alpn.ClientAuth = tls.NoClientCert
+			tc.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+					return alpn, nil
+				}
+				return nil, nil
+			}
+		}
+	}
+
+	return &tc, &src, nil
+}
+
+func getServers(ups *config.Upstream) ([]*upstream.Server, error) {
+	var servers []provider.Server
+	for _, s := range ups.Servers {
+		servers = append(servers, provider.Server{
+			Path: s.Path,
+			Options: []upstream.ServerOption{
+				upstream.WithWeight(s.Weight),
+				upstream.WithFailTimeout(time.Duration(s.FailTimeout) * time.Second),
+				upstream.WithMaxFail(s.MaxFail),
+				upstream.WithQueueSize(s.QueueSize),
+				upstream.WithQueueTimeout(s.QueueTimeout.Duration),
+				upstream.WithMaxConns(s.MaxConns),
+			},
+		})
+	}
+
+	var opts []upstream.ServerOption
+	if ups.HealthCheck != nil {
+		opts = append(opts, upstream.WithHealthCheck(getHealthCheck(ups.HealthCheck, &ups.Transport)))
+	}
+
+	return provider.New(ups.Provider, servers, opts...)
+}
+
+func getTransport(t *config.Transport) *http.Transport {
+	// tls config is validated by config.Parse
+	tc, _ := t.TLSConfig()
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   t.DialTimeout.Duration,
+			KeepAlive: t.KeepAlive.Duration,
+		}).DialContext,
+		MaxIdleConns:          t.MaxIdleConns,
+		MaxIdleConnsPerHost:   t.MaxIdleConns,
+		IdleConnTimeout:       t.IdleConnTimeout.Duration,
+		TLSHandshakeTimeout:   t.TLSHandshakeTimeout.Duration,
+		ResponseHeaderTimeout: t.ResponseHeaderTimeout.Duration,
+		TLSClientConfig:       tc,
+	}
+}
+
+func getHealthCheck(hc *config.HealthCheck, t *config.Transport) upstream.HealthCheck {
+	var p upstream.Prober
+
+	var opts []health.Option
+	if tc, _ := t.TLSConfig(); tc != nil {
+		opts = append(opts, health.WithTLS(tc))
+	}
+
+	switch hc.Type {
+	case config.TCPHealthCheck:
+		p = health.NewTCP()
+	case config.GRPCHealthCheck:
+		p = health.NewGRPC(hc.Service, opts...)
+	case config.UDPHealthCheck:
+		p = health.NewUDP(hc.Send)
+	default:
+		// expected status is validated by config.Parse
+		st, _ := health.ParseStatus(hc.ExpectedStatus)
+		p = health.NewHTTP(hc.Path, st, opts...)
+	}
+
+	return upstream.HealthCheck{
+		Prober:             p,
+		Interval:           hc.Interval.Duration,
+		Timeout:            hc.Timeout.Duration,
+		HealthyThreshold:   hc.HealthyThreshold,
+		UnhealthyThreshold: hc.UnhealthyThreshold,
+	}
 }
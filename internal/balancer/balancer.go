@@ -15,5 +15,8 @@ var (
 
 // Balancer represents balancing algorithm interface
 type Balancer interface {
-	NextServer() (*upstream.Server, error)
+	// NextServer selects the next server to receive traffic.
+	// key is the request affinity key used by hashing
+	// balancers, other balancers ignore it.
+	NextServer(key string) (*upstream.Server, error)
 }
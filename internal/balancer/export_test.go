@@ -0,0 +1,9 @@
+package balancer
+
+// Unregister removes factory registered under name
+// so that tests registering it can be run again
+func Unregister(name string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(factories, name)
+}
@@ -0,0 +1,79 @@
+package balancer
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+// virtualNodes is the number of ring points
+// assigned to a server per unit of weight
+const virtualNodes = 160
+
+// NewHash creates new Hash balancer instance
+func NewHash(s []*upstream.Server) *Hash {
+	bl := Hash{}
+
+	for _, srv := range s {
+		n := virtualNodes * weight(srv)
+		for i := 0; i < n; i++ {
+			bl.ring = append(bl.ring, node{
+				hash:   hashKey(srv.URI() + "#" + strconv.Itoa(i)),
+				server: srv,
+			})
+		}
+	}
+
+	sort.Slice(bl.ring, func(i, j int) bool { return bl.ring[i].hash < bl.ring[j].hash })
+
+	return &bl
+}
+
+// Hash represents consistent hashing load balancer
+// Servers are placed on a hash ring with a number of virtual nodes
+// proportional to their weight, and a key is mapped to the first
+// available server found clockwise from the key hash. This way
+// only keys of a server that became unavailable get remapped.
+type Hash struct {
+	ring []node
+}
+
+type node struct {
+	hash   uint32
+	server *upstream.Server
+}
+
+// NextServer returns upstream server owning the given key.
+// Requests with no key are spread randomly.
+func (bl *Hash) NextServer(key string) (*upstream.Server, error) {
+	n := len(bl.ring)
+	if n == 0 {
+		return nil, ErrUpstreamUnavailable
+	}
+
+	var i int
+	if key == "" {
+		i = rand.Intn(n)
+	} else {
+		h := hashKey(key)
+		i = sort.Search(n, func(i int) bool { return bl.ring[i].hash >= h })
+	}
+
+	for j := 0; j < n; j++ {
+		s := bl.ring[(i+j)%n].server
+		if s.Available() {
+			return s, nil
+		}
+	}
+
+	return nil, ErrUpstreamUnavailable
+}
+
+func hashKey(key string) uint32 {
+	sum := md5.Sum([]byte(key))
+	return binary.LittleEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,118 @@
+package balancer_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+func TestHash(t *testing.T) {
+	cases := map[string]struct {
+		servers func() []*upstream.Server
+		assert  func(*testing.T, []*upstream.Server, *balancer.Hash)
+	}{
+		"same key same server": {
+			servers: func() []*upstream.Server {
+				return dummyServers(5, false)
+			},
+			assert: func(t *testing.T, s []*upstream.Server, bl *balancer.Hash) {
+				for i := 0; i < 100; i++ {
+					key := fmt.Sprintf("tenant-%d", i)
+					want := mustNext(t, bl, key)
+					for j := 0; j < 5; j++ {
+						assert.Equal(t, want, mustNext(t, bl, key))
+					}
+				}
+			},
+		},
+		"weighted distribution": {
+			servers: func() []*upstream.Server {
+				return dummyServers(4, true)
+			},
+			assert: func(t *testing.T, s []*upstream.Server, bl *balancer.Hash) {
+				dist := distribution(t, bl, 12000)
+				// weights 0 (counts as 1), 1, 2, 3
+				assert.InDelta(t, 2000, dist[s[0]], 700)
+				assert.InDelta(t, 2000, dist[s[1]], 700)
+				assert.InDelta(t, 4000, dist[s[2]], 1000)
+				assert.InDelta(t, 6000, dist[s[3]], 1000)
+			},
+		},
+		"no key": {
+			servers: func() []*upstream.Server {
+				return dummyServers(3, false)
+			},
+			assert: func(t *testing.T, s []*upstream.Server, bl *balancer.Hash) {
+				seen := make(map[*upstream.Server]bool)
+				for i := 0; i < 100; i++ {
+					seen[mustNext(t, bl, "")] = true
+				}
+				assert.Equal(t, 3, len(seen))
+			},
+		},
+		"minimal remapping": {
+			servers: func() []*upstream.Server {
+				return dummyServers(4, false)
+			},
+			assert: func(t *testing.T, s []*upstream.Server, bl *balancer.Hash) {
+				before := make(map[string]*upstream.Server)
+				for i := 0; i < 1000; i++ {
+					key := fmt.Sprintf("10.0.0.%d", i)
+					before[key] = mustNext(t, bl, key)
+				}
+
+				failServer(t, s[1])
+				time.Sleep(240 * time.Millisecond)
+
+				for key, prev := range before {
+					srv := mustNext(t, bl, key)
+					assert.NotEqual(t, s[1], srv)
+					if prev != s[1] {
+						assert.Equal(t, prev, srv)
+					}
+				}
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := c.servers()
+			c.assert(t, s, balancer.NewHash(s))
+		})
+	}
+}
+
+func TestHashUnavailable(t *testing.T) {
+	s := dummyServers(2, false)
+	failServer(t, s[0])
+	failServer(t, s[1])
+	time.Sleep(240 * time.Millisecond)
+
+	bl := balancer.NewHash(s)
+	_, err := bl.NextServer("foo")
+	assert.Equal(t, balancer.ErrUpstreamUnavailable, err)
+
+	_, err = balancer.NewHash(nil).NextServer("foo")
+	assert.Equal(t, balancer.ErrUpstreamUnavailable, err)
+}
+
+func mustNext(t *testing.T, bl balancer.Balancer, key string) *upstream.Server {
+	s, err := bl.NextServer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func distribution(t *testing.T, bl balancer.Balancer, n int) map[*upstream.Server]int {
+	dist := make(map[*upstream.Server]int)
+	for i := 0; i < n; i++ {
+		dist[mustNext(t, bl, fmt.Sprintf("key-%d", i))]++
+	}
+	return dist
+}
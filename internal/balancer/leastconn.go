@@ -0,0 +1,69 @@
+package balancer
+
+import (
+	"sync"
+
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+// NewLeastConn creates new LeastConn balancer instance
+func NewLeastConn(s []*upstream.Server) *LeastConn {
+	bl := LeastConn{
+		servers: s,
+	}
+
+	return &bl
+}
+
+// LeastConn represents least connections load balancer
+// It selects the available server with the fewest active
+// requests relative to its weight. Ties are resolved in
+// round robin fashion.
+type LeastConn struct {
+	servers []*upstream.Server
+	next    int
+	m       sync.Mutex
+}
+
+// NextServer returns next available upstream server to receive traffic
+func (bl *LeastConn) NextServer(string) (*upstream.Server, error) {
+	bl.m.Lock()
+	defer bl.m.Unlock()
+
+	var best *upstream.Server
+	var bi int
+
+	n := len(bl.servers)
+	for j := 0; j < n; j++ {
+		i := (bl.next + j) % n
+		s := bl.servers[i]
+		if !s.Available() {
+			continue
+		}
+		if best == nil || less(s, best) {
+			best = s
+			bi = i
+		}
+	}
+
+	if best == nil {
+		return nil, ErrUpstreamUnavailable
+	}
+
+	bl.next = bi + 1
+
+	return best, nil
+}
+
+// less reports whether a has fewer active requests than b
+// with respect to server weights (active / weight)
+func less(a, b *upstream.Server) bool {
+	return a.Active()*weight(b) < b.Active()*weight(a)
+}
+
+func weight(s *upstream.Server) int {
+	if s.Weight() < 1 {
+		return 1
+	}
+	return s.Weight()
+}
@@ -0,0 +1,103 @@
+package balancer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+func TestLeastConn(t *testing.T) {
+	cases := map[string]struct {
+		servers func() ([]*upstream.Server, []*upstream.Server)
+		n       int
+		acquire bool
+		wantErr error
+	}{
+		"idle servers rotate": {
+			n: 5,
+			servers: func() ([]*upstream.Server, []*upstream.Server) {
+				s := dummyServers(3, false)
+				return s, []*upstream.Server{s[0], s[1], s[2], s[0], s[1]}
+			},
+		},
+		"fewest active": {
+			n: 3,
+			servers: func() ([]*upstream.Server, []*upstream.Server) {
+				s := dummyServers(3, false)
+				acquire(s[0], 3)
+				acquire(s[1], 1)
+				acquire(s[2], 2)
+				return s, []*upstream.Server{s[1], s[1], s[1]}
+			},
+		},
+		"fewest active acquired": {
+			n:       6,
+			acquire: true,
+			servers: func() ([]*upstream.Server, []*upstream.Server) {
+				s := dummyServers(3, false)
+				acquire(s[0], 2)
+				return s, []*upstream.Server{s[1], s[2], s[1], s[2], s[0], s[1]}
+			},
+		},
+		"weighted": {
+			n: 2,
+			servers: func() ([]*upstream.Server, []*upstream.Server) {
+				s := dummyServers(4, true)
+				acquire(s[0], 3)
+				acquire(s[1], 2)
+				acquire(s[2], 3)
+				acquire(s[3], 4)
+				return s, []*upstream.Server{s[3], s[3]}
+			},
+		},
+		"health fail": {
+			n: 3,
+			servers: func() ([]*upstream.Server, []*upstream.Server) {
+				s := dummyServers(3, false)
+				acquire(s[0], 5)
+				failServer(t, s[1])
+				acquire(s[2], 5)
+				return s, []*upstream.Server{s[0], s[2], s[0]}
+			},
+		},
+		"all unhealthy": {
+			n: 3,
+			servers: func() ([]*upstream.Server, []*upstream.Server) {
+				s := dummyServers(2, false)
+				failServer(t, s[0])
+				failServer(t, s[1])
+				return s, nil
+			},
+			wantErr: balancer.ErrUpstreamUnavailable,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			sv, eseq := c.servers()
+			time.Sleep(240 * time.Millisecond)
+			bl := balancer.NewLeastConn(sv)
+			var seq []*upstream.Server
+			for i := 0; i < c.n; i++ {
+				srv, err := bl.NextServer("")
+				assert.Equal(t, c.wantErr, err)
+				if srv != nil {
+					if c.acquire {
+						srv.Acquire()
+					}
+					seq = append(seq, srv)
+				}
+			}
+			assert.Equal(t, eseq, seq)
+		})
+	}
+}
+
+func acquire(s *upstream.Server, n int) {
+	for i := 0; i < n; i++ {
+		s.Acquire()
+	}
+}
@@ -0,0 +1,59 @@
+package balancer
+
+import (
+	"math/rand"
+
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+// NewP2CEWMA creates new P2CEWMA balancer instance
+func NewP2CEWMA(s []*upstream.Server) *P2CEWMA {
+	bl := P2CEWMA{
+		servers: s,
+	}
+
+	return &bl
+}
+
+// P2CEWMA represents power of two choices load balancer
+// It picks two random available servers and selects the one
+// with lower cost, where cost is peak ewma latency multiplied
+// by the number of active requests.
+type P2CEWMA struct {
+	servers []*upstream.Server
+}
+
+// NextServer returns next available upstream server to receive traffic
+func (bl *P2CEWMA) NextServer(string) (*upstream.Server, error) {
+	var avail []*upstream.Server
+	for _, s := range bl.servers {
+		if s.Available() {
+			avail = append(avail, s)
+		}
+	}
+
+	n := len(avail)
+	switch n {
+	case 0:
+		return nil, ErrUpstreamUnavailable
+	case 1:
+		return avail[0], nil
+	}
+
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+
+	a, b := avail[i], avail[j]
+	if cost(b) < cost(a) {
+		return b, nil
+	}
+
+	return a, nil
+}
+
+func cost(s *upstream.Server) float64 {
+	return float64(s.Latency()) * float64(s.Active()+1)
+}
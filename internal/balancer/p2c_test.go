@@ -0,0 +1,90 @@
+package balancer_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+func TestP2CEWMA(t *testing.T) {
+	cases := map[string]struct {
+		servers func() ([]*upstream.Server, *upstream.Server)
+		n       int
+		wantErr error
+	}{
+		"single server": {
+			n: 5,
+			servers: func() ([]*upstream.Server, *upstream.Server) {
+				s := dummyServers(1, false)
+				return s, s[0]
+			},
+		},
+		"lower latency": {
+			n: 20,
+			servers: func() ([]*upstream.Server, *upstream.Server) {
+				s := dummyServers(2, false)
+				observe(s[0], 30*time.Millisecond)
+				observe(s[1], time.Millisecond)
+				return s, s[1]
+			},
+		},
+		"lower load": {
+			n: 20,
+			servers: func() ([]*upstream.Server, *upstream.Server) {
+				s := dummyServers(2, false)
+				observe(s[0], 10*time.Millisecond)
+				observe(s[1], 2*time.Millisecond)
+				acquire(s[1], 10)
+				return s, s[0]
+			},
+		},
+		"health fail": {
+			n: 20,
+			servers: func() ([]*upstream.Server, *upstream.Server) {
+				s := dummyServers(2, false)
+				observe(s[1], 30*time.Millisecond)
+				failServer(t, s[0])
+				return s, s[1]
+			},
+		},
+		"all unhealthy": {
+			n: 5,
+			servers: func() ([]*upstream.Server, *upstream.Server) {
+				s := dummyServers(2, false)
+				failServer(t, s[0])
+				failServer(t, s[1])
+				return s, nil
+			},
+			wantErr: balancer.ErrUpstreamUnavailable,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, want := c.servers()
+			time.Sleep(240 * time.Millisecond)
+			bl := balancer.NewP2CEWMA(s)
+			for i := 0; i < c.n; i++ {
+				srv, err := bl.NextServer("")
+				assert.Equal(t, c.wantErr, err)
+				assert.Equal(t, want, srv)
+			}
+		})
+	}
+}
+
+func observe(s *upstream.Server, d time.Duration) {
+	done := make(chan error)
+	s.Work <- upstream.Request{
+		Done: done,
+		F: func(context.Context, string) error {
+			time.Sleep(d)
+			return nil
+		},
+	}
+	<-done
+}
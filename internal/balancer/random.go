@@ -7,6 +7,8 @@ import (
 	"github.com/tonto/gourmet/internal/upstream"
 )
 
+const selectTimeout = 10 * time.Millisecond
+
 // NewRandom creates new Random balancer instance
 func NewRandom(s []*upstream.Server) *Random {
 	r := Random{
@@ -22,11 +24,11 @@ type Random struct {
 }
 
 // NextServer returns next available upstream server to receive traffic
-func (r *Random) NextServer() (*upstream.Server, error) {
+func (r *Random) NextServer(string) (*upstream.Server, error) {
 	t := time.Now()
 	s := r.nextServer()
 	for !s.Available() {
-		if time.Since(t) > selectTiemout {
+		if time.Since(t) > selectTimeout {
 			return nil, ErrUpstreamUnavailable
 		}
 		s = r.nextServer()
@@ -39,7 +39,7 @@ func TestRandom(t *testing.T) {
 			time.Sleep(240 * time.Millisecond)
 			bl := balancer.NewRandom(s)
 			for i := 0; i < c.n; i++ {
-				_, err := bl.NextServer()
+				_, err := bl.NextServer("")
 				assert.Equal(t, c.wantErr, err)
 			}
 		})
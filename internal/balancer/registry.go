@@ -0,0 +1,92 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+// Names of balancers registered by this package
+const (
+	RoundRobinName = "round_robin"
+	RandomName     = "random"
+	LeastConnName  = "least_conn"
+	HashName       = "hash"
+	P2CEWMAName    = "p2c_ewma"
+)
+
+// ErrUnknown is returned when creating a balancer
+// under a name no factory is registered for
+var ErrUnknown = errors.New("unknown balancer")
+
+// Factory creates balancer selecting from servers
+type Factory func(servers []*upstream.Server) Balancer
+
+var (
+	m         sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+func init() {
+	Register(RoundRobinName, func(s []*upstream.Server) Balancer { return NewRoundRobin(s) })
+	Register(RandomName, func(s []*upstream.Server) Balancer { return NewRandom(s) })
+	Register(LeastConnName, func(s []*upstream.Server) Balancer { return NewLeastConn(s) })
+	Register(HashName, func(s []*upstream.Server) Balancer { return NewHash(s) })
+	Register(P2CEWMAName, func(s []*upstream.Server) Balancer { return NewP2CEWMA(s) })
+}
+
+// Register makes balancer factory available under name
+// so that it can be referred to by upstream config.
+// It is meant to be called from init and panics if
+// factory is nil or name is already registered.
+func Register(name string, f Factory) {
+	m.Lock()
+	defer m.Unlock()
+
+	if f == nil {
+		panic("balancer: Register factory is nil")
+	}
+	if _, ok := factories[name]; ok {
+		panic("balancer: Register called twice for " + name)
+	}
+
+	factories[name] = f
+}
+
+// Registered reports whether factory is registered under name
+func Registered(name string) bool {
+	m.RLock()
+	defer m.RUnlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// Names returns sorted names of registered balancers
+func Names() []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	var names []string
+	for n := range factories {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// New creates balancer registered under name
+func New(name string, servers []*upstream.Server) (Balancer, error) {
+	m.RLock()
+	f, ok := factories[name]
+	m.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknown, name)
+	}
+
+	return f(servers), nil
+}
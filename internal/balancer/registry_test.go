@@ -0,0 +1,52 @@
+package balancer_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+type firstBalancer []*upstream.Server
+
+func (b firstBalancer) NextServer(string) (*upstream.Server, error) { return b[0], nil }
+
+func TestRegistry(t *testing.T) {
+	balancer.Register("test_first", func(s []*upstream.Server) balancer.Balancer { return firstBalancer(s) })
+	t.Cleanup(func() { balancer.Unregister("test_first") })
+
+	cases := map[string]struct {
+		name    string
+		want    interface{}
+		wantErr error
+	}{
+		"round robin": {name: balancer.RoundRobinName, want: &balancer.RoundRobin{}},
+		"random":      {name: balancer.RandomName, want: &balancer.Random{}},
+		"least conn":  {name: balancer.LeastConnName, want: &balancer.LeastConn{}},
+		"hash":        {name: balancer.HashName, want: &balancer.Hash{}},
+		"p2c ewma":    {name: balancer.P2CEWMAName, want: &balancer.P2CEWMA{}},
+		"registered":  {name: "test_first", want: firstBalancer{}},
+		"unknown":     {name: "round_rob", wantErr: balancer.ErrUnknown},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			b, err := balancer.New(c.name, dummyServers(2, false))
+			if c.wantErr != nil {
+				assert.True(t, errors.Is(err, c.wantErr), "%v", err)
+				assert.False(t, balancer.Registered(c.name))
+				return
+			}
+			assert.Nil(t, err)
+			assert.IsType(t, c.want, b)
+			assert.True(t, balancer.Registered(c.name))
+			assert.Contains(t, balancer.Names(), c.name)
+		})
+	}
+
+	assert.Panics(t, func() {
+		balancer.Register(balancer.RoundRobinName, func(s []*upstream.Server) balancer.Balancer { return nil })
+	})
+}
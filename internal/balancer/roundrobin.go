@@ -2,70 +2,97 @@ package balancer
 
 import (
 	"sync"
-	"time"
 
 	"github.com/tonto/gourmet/internal/upstream"
 )
 
-const selectTiemout = 10 * time.Millisecond
-
 // NewRoundRobin creates new RoundRobin instance
 func NewRoundRobin(s []*upstream.Server) *RoundRobin {
-	bl := RoundRobin{
-		servers: s,
-		wmap:    make(map[*upstream.Server]int),
+	bl := RoundRobin{}
+
+	for _, srv := range s {
+		w := weight(srv)
+		bl.peers = append(bl.peers, &peer{
+			server:    srv,
+			weight:    w,
+			effective: w,
+		})
 	}
 
 	return &bl
 }
 
-// RoundRobin represents round robin load balancer
+// RoundRobin represents smooth weighted round robin load balancer
+// (as implemented by nginx). Selections of weighted servers are
+// interleaved instead of being sent in bursts, eg. weights
+// a=5, b=1, c=1 yield a a b a c a a sequence.
+//
+// Every failed request observed on a server reduces its effective
+// weight, which then recovers gradually on each selection round.
 type RoundRobin struct {
-	servers []*upstream.Server
-	wmap    map[*upstream.Server]int
-	next    int32
-	m       sync.Mutex
+	peers []*peer
+	m     sync.Mutex
 }
 
-// NextServer returns next available upstream server to receive traffic
-func (bl *RoundRobin) NextServer() (*upstream.Server, error) {
-	t := time.Now()
-	s := bl.nextServer()
-	for !s.Available() {
-		if time.Since(t) > selectTiemout {
-			return nil, ErrUpstreamUnavailable
-		}
-		s = bl.nextServer()
-	}
-	return s, nil
+type peer struct {
+	server    *upstream.Server
+	weight    int
+	effective int
+	current   int
+	fails     int
 }
 
-func (bl *RoundRobin) nextServer() *upstream.Server {
+// NextServer returns next available upstream server to receive traffic
+func (bl *RoundRobin) NextServer(string) (*upstream.Server, error) {
 	bl.m.Lock()
 	defer bl.m.Unlock()
 
-	i := bl.next
-	next := bl.next + 1
+	var best *peer
+	total := 0
+
+	for _, p := range bl.peers {
+		if !p.server.Available() {
+			continue
+		}
+
+		p.adjust()
 
-	if i >= int32(len(bl.servers)) {
-		next = 1
-		i = 0
+		p.current += p.effective
+		total += p.effective
+
+		if best == nil || p.current > best.current {
+			best = p
+		}
 	}
 
-	cs := bl.servers[i]
+	if best == nil {
+		return nil, ErrUpstreamUnavailable
+	}
 
-	if cs.Weight() > 1 {
-		nc := bl.wmap[cs] + 1
-		if nc == cs.Weight() {
-			bl.wmap[cs] = 0
+	best.current -= total
+
+	return best.server, nil
+}
+
+// adjust reduces effective weight by weight / max_fail for
+// every failure since last round, or restores it by one
+func (p *peer) adjust() {
+	fails := p.server.Fails()
+
+	if fails > p.fails {
+		step := 1
+		if mf := p.server.MaxFail(); mf > 0 && p.weight/mf > 1 {
+			step = p.weight / mf
 		}
-		if nc < cs.Weight() {
-			next = i
-			bl.wmap[cs]++
+		p.effective -= (fails - p.fails) * step
+		if p.effective < 0 {
+			p.effective = 0
 		}
+		p.fails = fails
+		return
 	}
 
-	bl.next = next
-
-	return bl.servers[i]
+	if p.effective < p.weight {
+		p.effective++
+	}
 }
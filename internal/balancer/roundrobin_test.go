@@ -44,14 +44,14 @@ func TestRoundRobin(t *testing.T) {
 			n: 5,
 			servers: func() ([]*upstream.Server, []*upstream.Server) {
 				s := dummyServers(5, true)
-				return s, []*upstream.Server{s[0], s[1], s[2], s[2], s[3]}
+				return s, []*upstream.Server{s[4], s[3], s[2], s[4], s[0]}
 			},
 		},
 		"weighted overflow": {
 			n: 11,
 			servers: func() ([]*upstream.Server, []*upstream.Server) {
 				s := dummyServers(4, true)
-				return s, []*upstream.Server{s[0], s[1], s[2], s[2], s[3], s[3], s[3], s[0], s[1], s[2], s[2]}
+				return s, []*upstream.Server{s[3], s[2], s[0], s[3], s[1], s[2], s[3], s[3], s[2], s[0], s[3]}
 			},
 		},
 		"weighted overflow health fail": {
@@ -60,7 +60,7 @@ func TestRoundRobin(t *testing.T) {
 				s := dummyServers(5, true)
 				failServer(t, s[1])
 				failServer(t, s[4])
-				return s, []*upstream.Server{s[0], s[2], s[2], s[3], s[3], s[3], s[0], s[2], s[2], s[3], s[3]}
+				return s, []*upstream.Server{s[3], s[2], s[0], s[3], s[2], s[3], s[3], s[2], s[0], s[3], s[2]}
 			},
 		},
 		"all unhealthy": {
@@ -82,7 +82,7 @@ func TestRoundRobin(t *testing.T) {
 			bl := balancer.NewRoundRobin(sv)
 			var seq []*upstream.Server
 			for i := 0; i < c.n; i++ {
-				srv, err := bl.NextServer()
+				srv, err := bl.NextServer("")
 				assert.Equal(t, c.wantErr, err)
 				if srv != nil {
 					seq = append(seq, srv)
@@ -93,6 +93,94 @@ func TestRoundRobin(t *testing.T) {
 	}
 }
 
+func TestRoundRobinDistribution(t *testing.T) {
+	cases := map[string]struct {
+		weights []int
+		n       int
+		wantSeq []int
+	}{
+		"smooth": {
+			weights: []int{5, 1, 1},
+			n:       14,
+			wantSeq: []int{0, 0, 1, 0, 2, 0, 0, 0, 0, 1, 0, 2, 0, 0},
+		},
+		"interleaved": {
+			weights: []int{2, 2, 1},
+			n:       5,
+			wantSeq: []int{0, 1, 2, 0, 1},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := weightedServers(time.Second, c.weights...)
+			bl := balancer.NewRoundRobin(s)
+
+			dist := make(map[*upstream.Server]int)
+			var seq []*upstream.Server
+			var wantSeq []*upstream.Server
+			for i := 0; i < c.n; i++ {
+				srv, err := bl.NextServer("")
+				assert.NoError(t, err)
+				dist[srv]++
+				seq = append(seq, srv)
+				wantSeq = append(wantSeq, s[c.wantSeq[i]])
+			}
+			assert.Equal(t, wantSeq, seq)
+
+			sum := 0
+			for _, w := range c.weights {
+				sum += w
+			}
+			for i, w := range c.weights {
+				assert.Equal(t, c.n*w/sum, dist[s[i]])
+			}
+		})
+	}
+}
+
+func TestRoundRobinEffectiveWeight(t *testing.T) {
+	s := weightedServers(10*time.Second, 4, 4)
+	bl := balancer.NewRoundRobin(s)
+
+	failServer(t, s[0])
+
+	var seq []*upstream.Server
+	for i := 0; i < 4; i++ {
+		srv, err := bl.NextServer("")
+		assert.NoError(t, err)
+		seq = append(seq, srv)
+	}
+	// s[0] effective weight drops to 0 and then recovers by one each round
+	assert.Equal(t, []*upstream.Server{s[1], s[1], s[0], s[1]}, seq)
+
+	dist := make(map[*upstream.Server]int)
+	for i := 0; i < 100; i++ {
+		srv, err := bl.NextServer("")
+		assert.NoError(t, err)
+		dist[srv]++
+	}
+	assert.InDelta(t, 50, dist[s[0]], 2)
+	assert.InDelta(t, 50, dist[s[1]], 2)
+}
+
+func weightedServers(failTimeout time.Duration, weights ...int) []*upstream.Server {
+	var s []*upstream.Server
+	for i, w := range weights {
+		srv := upstream.NewServer(
+			fmt.Sprintf("http://host%d.com", i),
+			upstream.WithWeight(w),
+			upstream.WithFailTimeout(failTimeout),
+			upstream.WithMaxFail(1),
+			upstream.WithQueueSize(5),
+		)
+		c := make(chan struct{})
+		go func() { srv.Run(c) }()
+		s = append(s, srv)
+	}
+	return s
+}
+
 func dummyServers(n int, w bool) []*upstream.Server {
 	var s []*upstream.Server
 	for i := 0; i < n; i++ {
@@ -101,7 +189,7 @@ func dummyServers(n int, w bool) []*upstream.Server {
 			wg = i
 		}
 		srv := upstream.NewServer(
-			"http://host1.com",
+			fmt.Sprintf("http://host%d.com", i),
 			upstream.WithWeight(wg),
 			upstream.WithFailTimeout(200*time.Millisecond),
 			upstream.WithMaxFail(1),
@@ -3,24 +3,77 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
-	"github.com/tonto/gourmet/internal/upstream"
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/health"
+	"github.com/tonto/gourmet/internal/provider"
 )
 
 const (
 	// RoundRobinAlg represents round robin balancer config label
-	RoundRobinAlg = "round_robin"
+	RoundRobinAlg = balancer.RoundRobinName
 
 	// RandomAlg represents random balancer config label
-	RandomAlg = "random"
+	RandomAlg = balancer.RandomName
+
+	// LeastConnAlg represents least connections balancer config label
+	LeastConnAlg = balancer.LeastConnName
+
+	// HashAlg represents consistent hash balancer config label
+	HashAlg = balancer.HashName
+
+	// P2CEWMAAlg represents power of two choices
+	// latency aware balancer config label
+	P2CEWMAAlg = balancer.P2CEWMAName
 )
 
 const (
 	// StaticProvider represents static
 	// upstream server provider config label
-	StaticProvider = "static"
+	StaticProvider = provider.StaticName
+)
+
+const (
+	// HTTPHealthCheck represents http health check probe config label
+	HTTPHealthCheck = "http"
+
+	// TCPHealthCheck represents tcp connect health check probe config label
+	TCPHealthCheck = "tcp"
+
+	// GRPCHealthCheck represents grpc.health.v1 health check probe config label
+	GRPCHealthCheck = "grpc"
+
+	// UDPHealthCheck represents udp request / reply health check probe config label
+	UDPHealthCheck = "udp"
+)
+
+const (
+	// IPHashKey represents client ip hash key config label
+	IPHashKey = "ip"
+
+	// HeaderHashKeyPrefix represents request header hash key
+	// config label prefix eg. header:X-Tenant
+	HeaderHashKeyPrefix = "header:"
+
+	// CookieHashKeyPrefix represents request cookie hash key
+	// config label prefix eg. cookie:session
+	CookieHashKeyPrefix = "cookie:"
+)
+
+const (
+	// HTTPScheme represents plain http upstream scheme config label
+	HTTPScheme = "http"
+
+	// HTTPSScheme represents https upstream scheme config label
+	HTTPSScheme = "https"
 )
 
 const (
@@ -32,42 +85,101 @@ var (
 	errNoUpstreams       = errors.New("upstream block missing or no upstreams listed")
 	errNoServers         = errors.New("if using static upstream server provider (default) server list should not be empty")
 	errNoServerPath      = errors.New("upstream server path must not be empty")
+	errNegativeLimit     = errors.New("upstream server max_conns, queue_size and queue_timeout must not be negative")
 	errNoServer          = errors.New("server block not present")
 	errNoServerLocations = errors.New("no server locations block present")
+	errInvalidHashKey    = errors.New("hash_key must be one of ip, header:<name> or cookie:<name>")
+	errInvalidCheckType  = errors.New("health check type must be one of http, tcp, grpc or udp")
+	errInvalidStatus     = errors.New("health check expected_status must be a list of status codes or ranges eg. 200-299")
+	errCheckInterval     = errors.New("health check interval and timeout must be positive")
+	errCheckThreshold    = errors.New("health check healthy_threshold and unhealthy_threshold must be at least 1")
 	errInvalidTOML       = errors.New("invalid format for config file")
+	errInvalidType       = errors.New("invalid value type")
+	errInvalidValue      = errors.New("invalid value")
+	errUnknownKey        = errors.New("unknown key")
+	errNoStreamPort      = errors.New("stream port must be set")
+	errStreamPass        = errors.New("stream must have exactly one of tcp_pass or udp_pass")
+	errStreamRoutes      = errors.New("stream routes are only supported by tcp streams")
+	errStreamRouteName   = errors.New("stream route server_name must be a host name or wildcard eg. *.foo.com")
+	errLocationPath      = errors.New("server location path must be a valid regexp")
+	errLocationPass      = errors.New("server location must have exactly one of http_pass, grpc_pass or fastcgi_pass")
+	errInvalidSplitPath  = errors.New("fastcgi split_path must be a valid regexp with two capture groups")
+	errFastCGITimeout    = errors.New("fastcgi connect_timeout and read_timeout must not be negative")
+	errLocationCert      = errors.New("server location client_cert requires tls client_ca_file")
 )
 
-// Provider represents an interface that should be implemented
-// by upstream providers in order to supply load balancing endpoints
-type Provider interface {
-	Servers() []*upstream.Server
-
-	// Sync returns a channel over which Provider
-	// implementer should signal a change in the hosts config.
-	// After receiving the signal receiver should call Upstreams() again
-	// and reset the next pointer if applicable (such as with round-robin)
-	Sync() chan struct{}
-}
-
 // Parse parses config file and creates new config instance
+// All problems found are returned as Problems error.
 func Parse(r io.Reader) (*Config, error) {
-	cfg, err := parse(r)
-	if err != nil {
-		return nil, errInvalidTOML
-	}
+	return parse("", r)
+}
 
-	err = cfg.validate()
+// ParseFile parses config file at path, problems
+// found are located by file name and line
+func ParseFile(file string) (*Config, error) {
+	r, err := os.Open(file)
 	if err != nil {
 		return nil, err
 	}
+	defer r.Close()
 
-	return cfg, nil
+	return parse(file, r)
 }
 
-func parse(r io.Reader) (*Config, error) {
+func parse(file string, r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
 	cfg := Config{}
-	_, err := toml.DecodeReader(r, &cfg)
-	return &cfg, err
+
+	var ps Problems
+
+	md, err := toml.Decode(string(data), &cfg)
+	if err != nil {
+		// values of invalid type are reported along
+		// with other problems found in the rest of config
+		cfg = Config{}
+		md, err = stripTypes(data, &cfg, err, &ps)
+	}
+	if err != nil {
+		p := decodeProblem(err)
+		p.File = file
+		return nil, Problems{p}
+	}
+
+	idx := indexKeys(data)
+
+	// keys of unknown tables are not reported on their own
+	unknown := make(map[string]bool)
+	seen := make(map[string]int)
+	for _, k := range md.Undecoded() {
+		u := k.String()
+		n := seen[u]
+		seen[u]++
+
+		if len(k) > 1 && unknown[k[:len(k)-1].String()] {
+			unknown[u] = true
+			continue
+		}
+		unknown[u] = true
+
+		key := u
+		if occ := idx.occurrences[u]; n < len(occ) {
+			key = occ[n]
+		}
+		ps.add(key, errUnknownKey)
+	}
+
+	cfg.validate(&ps)
+
+	if len(ps) > 0 {
+		ps.locate(file, idx)
+		return nil, ps
+	}
+
+	return &cfg, nil
 }
 
 // Config represents gourmet config struct and provides
@@ -75,6 +187,7 @@ func parse(r io.Reader) (*Config, error) {
 type Config struct {
 	Upstreams map[string]*Upstream
 	Server    *Server
+	Streams   []*Stream
 }
 
 // Upstream represents upstream config resource
@@ -82,68 +195,350 @@ type Upstream struct {
 	Balancer string
 	Provider string
 
+	// HashKey is only used with hash balancer
+	HashKey string `toml:"hash_key"`
+
+	// NextUpstream enables passing requests to the next
+	// server if selected server queue is full
+	NextUpstream bool `toml:"next_upstream"`
+
+	// HealthCheck enables active health checks if present
+	HealthCheck *HealthCheck `toml:"health_check"`
+
+	Transport
+
 	// Servers should be ignored if Provider is not static
 	Servers []*UpstreamServer
 }
 
+// Transport represents upstream connection pool config resource
+type Transport struct {
+	MaxIdleConns          int      `toml:"max_idle_conns"`
+	IdleConnTimeout       Duration `toml:"idle_conn_timeout"`
+	DialTimeout           Duration `toml:"dial_timeout"`
+	TLSHandshakeTimeout   Duration `toml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout Duration `toml:"response_header_timeout"`
+	KeepAlive             Duration `toml:"keep_alive"`
+
+	// Scheme is either http (default) or https
+	Scheme string
+
+	UpstreamTLS
+}
+
 // UpstreamServer represents upstream server config resource
 type UpstreamServer struct {
 	Path        string
 	Weight      int
 	MaxFail     int `toml:"max_fail"`
 	FailTimeout int `toml:"fail_timeout"`
+
+	// MaxConns limits open connections, tcp streams
+	// hold one for as long as they are proxied
+	MaxConns int `toml:"max_conns"`
+
+	QueueSize    int      `toml:"queue_size"`
+	QueueTimeout Duration `toml:"queue_timeout"`
+}
+
+// HealthCheck represents upstream active health check config resource
+type HealthCheck struct {
+	Type string
+
+	// Path and ExpectedStatus are only used with http type,
+	// Service only with grpc type and Send only with udp type
+	Path               string
+	Service            string
+	Send               string
+	Interval           Duration
+	Timeout            Duration
+	HealthyThreshold   int    `toml:"healthy_threshold"`
+	UnhealthyThreshold int    `toml:"unhealthy_threshold"`
+	ExpectedStatus     string `toml:"expected_status"`
+}
+
+// Duration represents duration config value eg. "5s"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
 }
 
 // Server represents server config resource
 type Server struct {
-	// TODO - Add SSL cert and keyfile
 	Port      int
 	Locations []ServerLocation
+
+	// TLS enables tls termination on Port if present
+	TLS *TLS `toml:"tls"`
 }
 
 // ServerLocation represents location config resource
 type ServerLocation struct {
 	Path     string
 	HTTPPass string `toml:"http_pass"`
+
+	// GRPCPass passes requests to upstream over
+	// cleartext HTTP/2 (h2c) instead of HTTP/1
+	GRPCPass string `toml:"grpc_pass"`
+
+	// FastCGIPass passes requests to upstream
+	// fastcgi servers (eg. php-fpm) configured by FastCGI
+	FastCGIPass string   `toml:"fastcgi_pass"`
+	FastCGI     *FastCGI `toml:"fastcgi"`
+
+	// FlushInterval is the interval at which response body is
+	// flushed to the client, negative value flushes immediately
+	FlushInterval Duration `toml:"flush_interval"`
+
+	// IdleTimeout is the time after which upgraded
+	// (eg. websocket) connections with no traffic are closed
+	IdleTimeout Duration `toml:"idle_timeout"`
+
+	// ClientCert requires a verified tls client certificate
+	ClientCert bool `toml:"client_cert"`
 }
 
-func (cfg *Config) validate() error {
-	if cfg.Upstreams == nil || len(cfg.Upstreams) == 0 {
-		return errNoUpstreams
-	}
+// FastCGI represents fastcgi location config resource
+type FastCGI struct {
+	Root      string
+	Index     string
+	SplitPath string `toml:"split_path"`
+	Params    map[string]string
 
-	for _, ups := range cfg.Upstreams {
-		cfg.setUpstreamDefaults(ups)
-		if ups.Provider == StaticProvider &&
-			(ups.Servers == nil || len(ups.Servers) == 0) {
-			return errNoServers
-		}
-		for _, s := range ups.Servers {
-			if s.Path == "" {
-				return errNoServerPath
-			}
+	ConnectTimeout Duration `toml:"connect_timeout"`
+
+	// ReadTimeout bounds the time until
+	// response headers are received
+	ReadTimeout Duration `toml:"read_timeout"`
+}
+
+// Stream represents tcp or udp stream listener config resource
+type Stream struct {
+	Port    int
+	TCPPass string `toml:"tcp_pass"`
+	UDPPass string `toml:"udp_pass"`
+
+	// ConnectTimeout is only used with tcp streams
+	ConnectTimeout Duration `toml:"connect_timeout"`
+	IdleTimeout    Duration `toml:"idle_timeout"`
+
+	// Routes enable tls passthrough, connections are routed by
+	// client hello server name without terminating tls.
+	// Connections matching no route are passed to TCPPass
+	// if set or closed otherwise.
+	Routes []*StreamRoute
+}
+
+// StreamRoute represents tls passthrough route config resource
+type StreamRoute struct {
+	// ServerName is exact host name or a wildcard
+	// such as *.foo.com matching any of its subdomains
+	ServerName string `toml:"server_name"`
+
+	// ALPN optionally restricts the route to clients
+	// offering any of the listed protocols
+	ALPN    []string `toml:"alpn"`
+	TCPPass string   `toml:"tcp_pass"`
+}
+
+// Pass returns the name of the upstream location passes
+// requests to, regardless of the protocol used
+func (loc *ServerLocation) Pass() string {
+	for _, p := range []string{loc.HTTPPass, loc.GRPCPass, loc.FastCGIPass} {
+		if p != "" {
+			return p
 		}
 	}
+	return ""
+}
+
+// validate sets defaults and adds a problem
+// for each invalid or missing config value
+func (cfg *Config) validate(ps *Problems) {
+	if len(cfg.Upstreams) == 0 {
+		ps.add("upstreams", errNoUpstreams)
+	}
+
+	var names []string
+	for name := range cfg.Upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cfg.validateUpstream(joinKey("upstreams", name), cfg.Upstreams[name], ps)
+	}
 
+	for i, st := range cfg.Streams {
+		cfg.validateStream(fmt.Sprintf("streams[%d]", i), st, ps)
+	}
+
+	// server block may be omitted if only streams are proxied
 	if cfg.Server == nil {
-		return errNoServer
+		if len(cfg.Streams) == 0 {
+			ps.add("server", errNoServer)
+		}
+		return
 	}
 
 	if cfg.Server.Port == 0 {
 		cfg.Server.Port = defaultPort
 	}
 
-	if cfg.Server.Locations == nil || len(cfg.Server.Locations) == 0 {
-		return errNoServerLocations
+	if t := cfg.Server.TLS; t != nil {
+		cfg.setTLSDefaults(t)
+		t.validate("server.tls", cfg.Server.Port, ps)
 	}
 
-	for _, loc := range cfg.Server.Locations {
-		if _, ok := cfg.Upstreams[loc.HTTPPass]; !ok {
-			return errUpstreamMismatch
+	if len(cfg.Server.Locations) == 0 {
+		ps.add("server.locations", errNoServerLocations)
+	}
+
+	for i := range cfg.Server.Locations {
+		cfg.validateLocation(fmt.Sprintf("server.locations[%d]", i), &cfg.Server.Locations[i], ps)
+	}
+}
+
+func (cfg *Config) validateUpstream(key string, ups *Upstream, ps *Problems) {
+	if err := ups.setServerSchemes(); err != nil {
+		ps.add(joinKey(key, "scheme"), err)
+	}
+	cfg.setUpstreamDefaults(ups)
+	if !balancer.Registered(ups.Balancer) {
+		ps.add(joinKey(key, "balancer"), fmt.Errorf(
+			"%w: %q, registered balancers are %s",
+			balancer.ErrUnknown, ups.Balancer, strings.Join(balancer.Names(), ", "),
+		))
+	}
+	if !provider.Registered(ups.Provider) {
+		ps.add(joinKey(key, "provider"), fmt.Errorf(
+			"%w: %q, registered providers are %s",
+			provider.ErrUnknown, ups.Provider, strings.Join(provider.Names(), ", "),
+		))
+	}
+	if ups.Provider == StaticProvider && len(ups.Servers) == 0 {
+		ps.add(joinKey(key, "servers"), errNoServers)
+	}
+	for i, s := range ups.Servers {
+		if s.Path == "" {
+			ps.add(fmt.Sprintf("%s.servers[%d].path", key, i), errNoServerPath)
+		}
+		if s.MaxConns < 0 {
+			ps.add(fmt.Sprintf("%s.servers[%d].max_conns", key, i), errNegativeLimit)
+		}
+		if s.QueueSize < 0 {
+			ps.add(fmt.Sprintf("%s.servers[%d].queue_size", key, i), errNegativeLimit)
 		}
+		if s.QueueTimeout.Duration < 0 {
+			ps.add(fmt.Sprintf("%s.servers[%d].queue_timeout", key, i), errNegativeLimit)
+		}
+	}
+	ups.Transport.validate(key, ps)
+	if ups.Balancer == HashAlg && !validHashKey(ups.HashKey) {
+		ps.add(joinKey(key, "hash_key"), errInvalidHashKey)
 	}
+	if hc := ups.HealthCheck; hc != nil {
+		key = joinKey(key, "health_check")
+		switch hc.Type {
+		case HTTPHealthCheck, TCPHealthCheck, GRPCHealthCheck, UDPHealthCheck:
+		default:
+			ps.add(joinKey(key, "type"), errInvalidCheckType)
+		}
+		if _, err := health.ParseStatus(hc.ExpectedStatus); err != nil {
+			ps.add(joinKey(key, "expected_status"), errInvalidStatus)
+		}
+		if hc.Interval.Duration <= 0 {
+			ps.add(joinKey(key, "interval"), errCheckInterval)
+		}
+		if hc.Timeout.Duration <= 0 {
+			ps.add(joinKey(key, "timeout"), errCheckInterval)
+		}
+		if hc.HealthyThreshold < 1 {
+			ps.add(joinKey(key, "healthy_threshold"), errCheckThreshold)
+		}
+		if hc.UnhealthyThreshold < 1 {
+			ps.add(joinKey(key, "unhealthy_threshold"), errCheckThreshold)
+		}
+	}
+}
 
-	return nil
+func (cfg *Config) validateStream(key string, st *Stream, ps *Problems) {
+	cfg.setStreamDefaults(st)
+	if st.Port == 0 {
+		ps.add(joinKey(key, "port"), errNoStreamPort)
+	}
+	if len(st.Routes) > 0 {
+		if st.UDPPass != "" {
+			ps.add(joinKey(key, "udp_pass"), errStreamRoutes)
+		}
+		for i, r := range st.Routes {
+			rkey := fmt.Sprintf("%s.routes[%d]", key, i)
+			if !hostnameRe.MatchString(strings.TrimPrefix(r.ServerName, "*.")) {
+				ps.add(joinKey(rkey, "server_name"), fmt.Errorf("%w: %q", errStreamRouteName, r.ServerName))
+			}
+			cfg.validatePass(joinKey(rkey, "tcp_pass"), r.TCPPass, ps)
+		}
+		if st.TCPPass == "" {
+			return
+		}
+	}
+	if (st.TCPPass == "") == (st.UDPPass == "") {
+		ps.add(key, errStreamPass)
+		return
+	}
+	if st.TCPPass != "" {
+		cfg.validatePass(joinKey(key, "tcp_pass"), st.TCPPass, ps)
+	} else {
+		cfg.validatePass(joinKey(key, "udp_pass"), st.UDPPass, ps)
+	}
+}
+
+func (cfg *Config) validateLocation(key string, loc *ServerLocation, ps *Problems) {
+	if _, err := regexp.Compile(loc.Path); err != nil {
+		ps.add(joinKey(key, "path"), fmt.Errorf("%w: %v", errLocationPath, err))
+	}
+	switch {
+	case loc.Pass() == "" || loc.Pass() != loc.HTTPPass+loc.GRPCPass+loc.FastCGIPass:
+		ps.add(key, errLocationPass)
+	case loc.HTTPPass != "":
+		cfg.validatePass(joinKey(key, "http_pass"), loc.HTTPPass, ps)
+	case loc.GRPCPass != "":
+		cfg.validatePass(joinKey(key, "grpc_pass"), loc.GRPCPass, ps)
+	default:
+		cfg.validatePass(joinKey(key, "fastcgi_pass"), loc.FastCGIPass, ps)
+	}
+	if loc.ClientCert && (cfg.Server.TLS == nil || cfg.Server.TLS.ClientCAFile == "") {
+		ps.add(joinKey(key, "client_cert"), errLocationCert)
+	}
+	if loc.FastCGIPass != "" {
+		if loc.FastCGI == nil {
+			loc.FastCGI = &FastCGI{}
+		}
+		cfg.setFastCGIDefaults(loc.FastCGI)
+		re, err := regexp.Compile(loc.FastCGI.SplitPath)
+		if err != nil || re.NumSubexp() != 2 {
+			ps.add(joinKey(key, "fastcgi.split_path"), errInvalidSplitPath)
+		}
+		if loc.FastCGI.ConnectTimeout.Duration < 0 {
+			ps.add(joinKey(key, "fastcgi.connect_timeout"), errFastCGITimeout)
+		}
+		if loc.FastCGI.ReadTimeout.Duration < 0 {
+			ps.add(joinKey(key, "fastcgi.read_timeout"), errFastCGITimeout)
+		}
+	}
+}
+
+// validatePass checks that upstream name passed to is defined
+func (cfg *Config) validatePass(key, name string, ps *Problems) {
+	if _, ok := cfg.Upstreams[name]; !ok {
+		ps.add(key, fmt.Errorf("%w: %q", errUpstreamMismatch, name))
+	}
 }
 
 func (cfg *Config) setUpstreamDefaults(u *Upstream) {
@@ -153,9 +548,64 @@ func (cfg *Config) setUpstreamDefaults(u *Upstream) {
 	if u.Balancer == "" {
 		u.Balancer = RoundRobinAlg
 	}
+	if u.Balancer == HashAlg && u.HashKey == "" {
+		u.HashKey = IPHashKey
+	}
 	for _, s := range u.Servers {
 		cfg.setUServerDefaults(s)
 	}
+	if u.HealthCheck != nil {
+		cfg.setHealthCheckDefaults(u.HealthCheck)
+	}
+	cfg.setTransportDefaults(&u.Transport)
+}
+
+func (*Config) setFastCGIDefaults(fc *FastCGI) {
+	if fc.Index == "" {
+		fc.Index = "index.php"
+	}
+	if fc.SplitPath == "" {
+		fc.SplitPath = `^(.+?\.php)(/.*)$`
+	}
+	if fc.ConnectTimeout.Duration == 0 {
+		fc.ConnectTimeout.Duration = 5 * time.Second
+	}
+	if fc.ReadTimeout.Duration == 0 {
+		fc.ReadTimeout.Duration = 60 * time.Second
+	}
+}
+
+func (*Config) setStreamDefaults(st *Stream) {
+	if st.ConnectTimeout.Duration == 0 {
+		st.ConnectTimeout.Duration = 5 * time.Second
+	}
+	if st.IdleTimeout.Duration == 0 {
+		st.IdleTimeout.Duration = 10 * time.Minute
+		if st.UDPPass != "" {
+			st.IdleTimeout.Duration = 30 * time.Second
+		}
+	}
+}
+
+func (*Config) setTransportDefaults(t *Transport) {
+	if t.MaxIdleConns == 0 {
+		t.MaxIdleConns = 100
+	}
+	if t.IdleConnTimeout.Duration == 0 {
+		t.IdleConnTimeout.Duration = 90 * time.Second
+	}
+	if t.DialTimeout.Duration == 0 {
+		t.DialTimeout.Duration = 30 * time.Second
+	}
+	if t.TLSHandshakeTimeout.Duration == 0 {
+		t.TLSHandshakeTimeout.Duration = 10 * time.Second
+	}
+	if t.KeepAlive.Duration == 0 {
+		t.KeepAlive.Duration = 30 * time.Second
+	}
+	if t.Scheme == "" {
+		t.Scheme = HTTPScheme
+	}
 }
 
 func (*Config) setUServerDefaults(s *UpstreamServer) {
@@ -165,4 +615,46 @@ func (*Config) setUServerDefaults(s *UpstreamServer) {
 	if s.FailTimeout == 0 {
 		s.FailTimeout = 1
 	}
+	if s.MaxConns == 0 {
+		s.MaxConns = 100
+	}
+	if s.QueueSize == 0 {
+		s.QueueSize = 100
+	}
+}
+
+func validHashKey(k string) bool {
+	if k == IPHashKey {
+		return true
+	}
+	for _, p := range []string{HeaderHashKeyPrefix, CookieHashKeyPrefix} {
+		if strings.HasPrefix(k, p) && len(k) > len(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (*Config) setHealthCheckDefaults(hc *HealthCheck) {
+	if hc.Type == "" {
+		hc.Type = HTTPHealthCheck
+	}
+	if hc.Path == "" {
+		hc.Path = "/"
+	}
+	if hc.Interval.Duration == 0 {
+		hc.Interval.Duration = 5 * time.Second
+	}
+	if hc.Timeout.Duration == 0 {
+		hc.Timeout.Duration = time.Second
+	}
+	if hc.HealthyThreshold == 0 {
+		hc.HealthyThreshold = 2
+	}
+	if hc.UnhealthyThreshold == 0 {
+		hc.UnhealthyThreshold = 3
+	}
+	if hc.ExpectedStatus == "" {
+		hc.ExpectedStatus = "200-299"
+	}
 }
@@ -1,14 +1,28 @@
 package config
 
 import (
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/provider"
 )
 
+var defaultTransport = Transport{
+	MaxIdleConns:        100,
+	IdleConnTimeout:     Duration{90 * time.Second},
+	DialTimeout:         Duration{30 * time.Second},
+	TLSHandshakeTimeout: Duration{10 * time.Second},
+	KeepAlive:           Duration{30 * time.Second},
+	Scheme:              "http",
+}
+
 func TestParseConfig(t *testing.T) {
 	cases := map[string]struct {
 		expectedCfg *Config
@@ -18,48 +32,496 @@ func TestParseConfig(t *testing.T) {
 		"upstream_err":             {expectedErr: errNoUpstreams},
 		"static_provider_err":      {expectedErr: errNoServers},
 		"static_provider_path_err": {expectedErr: errNoServerPath},
+		"server_limits_err":        {expectedErr: errNegativeLimit},
 		"server_err":               {expectedErr: errNoServer},
 		"server_locations_err":     {expectedErr: errNoServerLocations},
 		"upstream_mismatch":        {expectedErr: errUpstreamMismatch},
+		"hash_key_err":             {expectedErr: errInvalidHashKey},
+		"health_check_status_err":  {expectedErr: errInvalidStatus},
+		"health_check_type_err":    {expectedErr: errInvalidCheckType},
+		"check_interval_err":       {expectedErr: errCheckInterval},
+		"check_threshold_err":      {expectedErr: errCheckThreshold},
+		"stream_port_err":          {expectedErr: errNoStreamPort},
+		"stream_mismatch":          {expectedErr: errUpstreamMismatch},
+		"stream_pass_err":          {expectedErr: errStreamPass},
+		"stream_route_name_err":    {expectedErr: errStreamRouteName},
+		"stream_route_mismatch":    {expectedErr: errUpstreamMismatch},
+		"stream_routes_udp_err":    {expectedErr: errStreamRoutes},
+		"location_pass_err":        {expectedErr: errLocationPass},
+		"location_path_err":        {expectedErr: errLocationPath},
+		"fastcgi_split_path_err":   {expectedErr: errInvalidSplitPath},
+		"fastcgi_timeout_err":      {expectedErr: errFastCGITimeout},
+		"tls_no_cert":              {expectedErr: errNoTLSCert},
+		"tls_cert_err":             {expectedErr: errTLSCert},
+		"tls_key_mismatch":         {expectedErr: errTLSCert},
+		"tls_min_version_err":      {expectedErr: errTLSMinVersion},
+		"tls_cipher_err":           {expectedErr: errTLSCipher},
+		"tls_redirect_port_err":    {expectedErr: errRedirectPort},
+		"tls_sni_cert_err":         {expectedErr: errTLSCert},
+		"tls_sni_no_key":           {expectedErr: errNoTLSCert},
+		"acme_no_domains":          {expectedErr: errACMEDomains},
+		"acme_domain_err":          {expectedErr: errACMEDomains},
+		"acme_directory_err":       {expectedErr: errACMEDirectory},
+		"acme_challenge_err":       {expectedErr: errACMEChallenge},
+		"mtls_ca_err":              {expectedErr: errClientCA},
+		"mtls_auth_err":            {expectedErr: errClientAuth},
+		"mtls_no_ca":               {expectedErr: errClientAuthNoCA},
+		"location_client_cert_err": {expectedErr: errLocationCert},
+		"upstream_scheme_err":      {expectedErr: errUpstreamScheme},
+		"upstream_scheme_mismatch": {expectedErr: errUpstreamScheme},
+		"upstream_tls_err":         {expectedErr: errUpstreamTLS},
+		"upstream_ca_err":          {expectedErr: errUpstreamCA},
+		"upstream_client_cert_err": {expectedErr: errUpstreamClientCert},
 		"defaults": {
 			expectedCfg: &Config{
 				Upstreams: map[string]*Upstream{
-					"front":   &Upstream{Balancer: "round_robin", Provider: "static", Servers: []*UpstreamServer{&UpstreamServer{Path: "http://api.foo.com", Weight: 5, MaxFail: 10, FailTimeout: 1}}},
-					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Servers: []*UpstreamServer{&UpstreamServer{Path: "http://api.foo1.com", Weight: 5, MaxFail: 10, FailTimeout: 1}, &UpstreamServer{Path: "http://api.foo2.com", Weight: 0, MaxFail: 10, FailTimeout: 1}}}},
+					"front":   &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", Weight: 5, MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo1.com", Weight: 5, MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}, &UpstreamServer{Path: "api.foo2.com", Weight: 0, MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}}},
 				Server: &Server{Port: 8080, Locations: []ServerLocation{ServerLocation{Path: "/api", HTTPPass: "backend"}, ServerLocation{Path: "/", HTTPPass: "front"}}},
 			},
 		},
 		"valid": {
 			expectedCfg: &Config{
 				Upstreams: map[string]*Upstream{
-					"front":   &Upstream{Balancer: "round_robin", Provider: "static", Servers: []*UpstreamServer{&UpstreamServer{Path: "http://api.foo.com", Weight: 5, MaxFail: 15, FailTimeout: 5}}},
-					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Servers: []*UpstreamServer{&UpstreamServer{Path: "http://api.foo1.com", Weight: 5, MaxFail: 10, FailTimeout: 1}, &UpstreamServer{Path: "http://api.foo2.com", Weight: 0, MaxFail: 10, FailTimeout: 1}}},
+					"front":   &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, NextUpstream: true, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", Weight: 5, MaxFail: 15, FailTimeout: 5, MaxConns: 20, QueueSize: 10, QueueTimeout: Duration{2 * time.Second}}}},
+					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo1.com", Weight: 5, MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}, &UpstreamServer{Path: "api.foo2.com", Weight: 0, MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
 				},
-				Server: &Server{Port: 80, Locations: []ServerLocation{ServerLocation{Path: "/api", HTTPPass: "backend"}, ServerLocation{Path: "/", HTTPPass: "front"}}},
+				Server: &Server{Port: 80, Locations: []ServerLocation{ServerLocation{Path: "/api", HTTPPass: "backend", FlushInterval: Duration{100 * time.Millisecond}, IdleTimeout: Duration{5 * time.Minute}}, ServerLocation{Path: "/", HTTPPass: "front"}}},
 			},
 		},
 		"valid_random": {
 			expectedCfg: &Config{
 				Upstreams: map[string]*Upstream{
-					"front":   &Upstream{Balancer: "random", Provider: "static", Servers: []*UpstreamServer{&UpstreamServer{Path: "http://api.foo.com", Weight: 5, MaxFail: 15, FailTimeout: 5}}},
-					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Servers: []*UpstreamServer{&UpstreamServer{Path: "http://api.foo1.com", Weight: 5, MaxFail: 10, FailTimeout: 1}, &UpstreamServer{Path: "http://api.foo2.com", Weight: 0, MaxFail: 10, FailTimeout: 1}}},
+					"front":   &Upstream{Balancer: "random", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", Weight: 5, MaxFail: 15, FailTimeout: 5, MaxConns: 100, QueueSize: 100}}},
+					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo1.com", Weight: 5, MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}, &UpstreamServer{Path: "api.foo2.com", Weight: 0, MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
 				},
 				Server: &Server{Port: 80, Locations: []ServerLocation{ServerLocation{Path: "/api", HTTPPass: "backend"}, ServerLocation{Path: "/", HTTPPass: "front"}}},
 			},
 		},
-		// TODO
-		// Add tests for misspelled options eg. round_rob
+		"valid_hash": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"front":   &Upstream{Balancer: "hash", Provider: "static", Transport: defaultTransport, HashKey: "header:X-Tenant", Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", Weight: 5, MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+					"backend": &Upstream{Balancer: "hash", Provider: "static", Transport: defaultTransport, HashKey: "ip", Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo1.com", Weight: 5, MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}, &UpstreamServer{Path: "api.foo2.com", Weight: 0, MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+				},
+				Server: &Server{Port: 80, Locations: []ServerLocation{ServerLocation{Path: "/api", HTTPPass: "backend"}, ServerLocation{Path: "/", HTTPPass: "front"}}},
+			},
+		},
+		"valid_transport": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"backend": &Upstream{
+						Balancer: "round_robin",
+						Provider: "static",
+						Servers:  []*UpstreamServer{&UpstreamServer{Path: "api.foo1.com", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}},
+						Transport: Transport{
+							MaxIdleConns:          10,
+							IdleConnTimeout:       Duration{time.Minute},
+							DialTimeout:           Duration{time.Second},
+							TLSHandshakeTimeout:   Duration{2 * time.Second},
+							ResponseHeaderTimeout: Duration{5 * time.Second},
+							KeepAlive:             Duration{15 * time.Second},
+							Scheme:                "http",
+						},
+					},
+				},
+				Server: &Server{Port: 8080, Locations: []ServerLocation{ServerLocation{Path: "/", HTTPPass: "backend"}}},
+			},
+		},
+		"valid_health_check": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"front": &Upstream{
+						Balancer:  "round_robin",
+						Provider:  "static",
+						Transport: defaultTransport,
+						Servers:   []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}},
+						HealthCheck: &HealthCheck{
+							Type:               "grpc",
+							Service:            "foo.Bar",
+							Path:               "/",
+							Interval:           Duration{5 * time.Second},
+							Timeout:            Duration{time.Second},
+							HealthyThreshold:   2,
+							UnhealthyThreshold: 3,
+							ExpectedStatus:     "200-299",
+						},
+					},
+					"backend": &Upstream{
+						Balancer:  "round_robin",
+						Provider:  "static",
+						Transport: defaultTransport,
+						Servers:   []*UpstreamServer{&UpstreamServer{Path: "api.foo1.com", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}},
+						HealthCheck: &HealthCheck{
+							Type:               "http",
+							Path:               "/healthz",
+							Interval:           Duration{10 * time.Second},
+							Timeout:            Duration{500 * time.Millisecond},
+							HealthyThreshold:   1,
+							UnhealthyThreshold: 5,
+							ExpectedStatus:     "200,204",
+						},
+					},
+				},
+				Server: &Server{Port: 8080, Locations: []ServerLocation{ServerLocation{Path: "/api", HTTPPass: "backend"}, ServerLocation{Path: "/", HTTPPass: "front"}}},
+			},
+		},
+		"valid_grpc": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+					"users":   &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "users.foo.com:50051", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+				},
+				Server: &Server{Port: 8080, Locations: []ServerLocation{ServerLocation{Path: `/(users\.v1\.Users/.+)`, GRPCPass: "users"}, ServerLocation{Path: "/", HTTPPass: "backend"}}},
+			},
+		},
+		"valid_fastcgi": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"php":    &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "unix:/run/php/php-fpm.sock", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+					"legacy": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "legacy.foo.com:9000", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+				},
+				Server: &Server{Port: 8080, Locations: []ServerLocation{
+					ServerLocation{Path: "/(.+)", FastCGIPass: "php", FastCGI: &FastCGI{Root: "/var/www/app", Index: "app.php", SplitPath: `^(.+?\.php)(/.*)$`, Params: map[string]string{"APP_ENV": "prod"}, ConnectTimeout: Duration{time.Second}, ReadTimeout: Duration{5 * time.Minute}}},
+					ServerLocation{Path: "/legacy/(.+)", FastCGIPass: "legacy", FastCGI: &FastCGI{Index: "index.php", SplitPath: `^(.+?\.php)(/.*)$`, ConnectTimeout: Duration{5 * time.Second}, ReadTimeout: Duration{time.Minute}}},
+				}},
+			},
+		},
+		"valid_tls": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+				},
+				Server: &Server{
+					Port:      443,
+					Locations: []ServerLocation{ServerLocation{Path: "/", HTTPPass: "backend"}},
+					TLS: &TLS{
+						CertFile:       "testdata/tls/foo.crt",
+						KeyFile:        "testdata/tls/foo.key",
+						MinVersion:     "1.3",
+						Ciphers:        []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+						RedirectPort:   80,
+						ReloadInterval: Duration{time.Minute},
+					},
+				},
+			},
+		},
+		"valid_tls_sni": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+				},
+				Server: &Server{
+					Port:      443,
+					Locations: []ServerLocation{ServerLocation{Path: "/", HTTPPass: "backend"}},
+					TLS: &TLS{
+						MinVersion: "1.2",
+						Certificates: []*TLSCertificate{
+							&TLSCertificate{CertFile: "testdata/tls/foo.crt", KeyFile: "testdata/tls/foo.key"},
+							&TLSCertificate{CertFile: "testdata/tls/bar.crt", KeyFile: "testdata/tls/bar.key"},
+						},
+						ReloadInterval: Duration{10 * time.Second},
+					},
+				},
+			},
+		},
+		"valid_acme": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+				},
+				Server: &Server{
+					Port:      443,
+					Locations: []ServerLocation{ServerLocation{Path: "/", HTTPPass: "backend"}},
+					TLS: &TLS{
+						MinVersion:     "1.2",
+						RedirectPort:   80,
+						ReloadInterval: Duration{time.Minute},
+						ACME: &ACME{
+							Email:        "admin@foo.com",
+							Domains:      []string{"foo.com", "www.foo.com"},
+							DirectoryURL: "https://localhost:14000/dir",
+							StorageDir:   "/tmp/gourmet/acme",
+							Challenges:   []string{"tls-alpn-01"},
+							RenewBefore:  Duration{240 * time.Hour},
+						},
+					},
+				},
+			},
+		},
+		"valid_acme_defaults": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+				},
+				Server: &Server{
+					Port:      443,
+					Locations: []ServerLocation{ServerLocation{Path: "/", HTTPPass: "backend"}},
+					TLS: &TLS{
+						MinVersion:     "1.2",
+						RedirectPort:   80,
+						ReloadInterval: Duration{time.Minute},
+						ACME: &ACME{
+							Domains:      []string{"foo.com"},
+							DirectoryURL: "https://acme-v02.api.letsencrypt.org/directory",
+							StorageDir:   "/var/lib/gourmet/acme",
+							Challenges:   []string{"http-01", "tls-alpn-01"},
+							RenewBefore:  Duration{720 * time.Hour},
+						},
+					},
+				},
+			},
+		},
+		"valid_mtls": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+				},
+				Server: &Server{
+					Port: 443,
+					Locations: []ServerLocation{
+						ServerLocation{Path: "/admin/(.+)", HTTPPass: "backend", ClientCert: true},
+						ServerLocation{Path: "/(.+)", HTTPPass: "backend"},
+					},
+					TLS: &TLS{
+						CertFile:          "testdata/tls/foo.crt",
+						KeyFile:           "testdata/tls/foo.key",
+						MinVersion:        "1.2",
+						ReloadInterval:    Duration{time.Minute},
+						ClientCAFile:      "testdata/tls/foo.crt",
+						ClientAuth:        "verify_if_given",
+						ClientCertHeaders: &ClientCertHeaders{Subject: "X-SSL-Client-DN", SANs: "X-SSL-Client-SAN"},
+					},
+				},
+			},
+		},
+		"valid_mtls_defaults": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"backend": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "api.foo.com", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+				},
+				Server: &Server{
+					Port: 443,
+					Locations: []ServerLocation{
+						ServerLocation{Path: "/admin/(.+)", HTTPPass: "backend", ClientCert: true},
+						ServerLocation{Path: "/(.+)", HTTPPass: "backend"},
+					},
+					TLS: &TLS{
+						CertFile:       "testdata/tls/foo.crt",
+						KeyFile:        "testdata/tls/foo.key",
+						MinVersion:     "1.2",
+						ReloadInterval: Duration{time.Minute},
+						ClientCAFile:   "testdata/tls/foo.crt",
+						ClientAuth:     "require",
+						ClientCertHeaders: &ClientCertHeaders{
+							Subject:     "X-Client-Cert-Subject",
+							SANs:        "X-Client-Cert-SANs",
+							Fingerprint: "X-Client-Cert-Fingerprint",
+						},
+					},
+				},
+			},
+		},
+		"valid_upstream_tls": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"backend": &Upstream{
+						Balancer: "round_robin",
+						Provider: "static",
+						Transport: Transport{
+							MaxIdleConns:        100,
+							IdleConnTimeout:     Duration{90 * time.Second},
+							DialTimeout:         Duration{30 * time.Second},
+							TLSHandshakeTimeout: Duration{10 * time.Second},
+							KeepAlive:           Duration{30 * time.Second},
+							Scheme:              "https",
+							UpstreamTLS: UpstreamTLS{
+								CAFile:         "testdata/tls/foo.crt",
+								ClientCertFile: "testdata/tls/bar.crt",
+								ClientKeyFile:  "testdata/tls/bar.key",
+								ServerName:     "internal.foo.com",
+							},
+						},
+						Servers: []*UpstreamServer{&UpstreamServer{Path: "10.0.0.1:8443", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}},
+					},
+					"public": &Upstream{
+						Balancer: "round_robin",
+						Provider: "static",
+						Transport: Transport{
+							MaxIdleConns:        100,
+							IdleConnTimeout:     Duration{90 * time.Second},
+							DialTimeout:         Duration{30 * time.Second},
+							TLSHandshakeTimeout: Duration{10 * time.Second},
+							KeepAlive:           Duration{30 * time.Second},
+							Scheme:              "https",
+							UpstreamTLS:         UpstreamTLS{InsecureSkipVerify: true},
+						},
+						Servers: []*UpstreamServer{&UpstreamServer{Path: "api.bar.com", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}},
+					},
+				},
+				Server: &Server{Port: 8080, Locations: []ServerLocation{ServerLocation{Path: "/", HTTPPass: "backend"}}},
+			},
+		},
+		"valid_stream_routes": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"tenant_a": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "10.0.0.1:443", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+					"tenant_b": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "10.0.0.2:443", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+					"fallback": &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "10.0.0.3:443", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+				},
+				Streams: []*Stream{
+					&Stream{
+						Port:           443,
+						TCPPass:        "fallback",
+						ConnectTimeout: Duration{5 * time.Second},
+						IdleTimeout:    Duration{10 * time.Minute},
+						Routes: []*StreamRoute{
+							&StreamRoute{ServerName: "*.a.com", ALPN: []string{"h2"}, TCPPass: "tenant_a"},
+							&StreamRoute{ServerName: "b.com", TCPPass: "tenant_b"},
+						},
+					},
+					&Stream{
+						Port:           8443,
+						ConnectTimeout: Duration{5 * time.Second},
+						IdleTimeout:    Duration{10 * time.Minute},
+						Routes:         []*StreamRoute{&StreamRoute{ServerName: "a.com", TCPPass: "tenant_a"}},
+					},
+				},
+			},
+		},
+		"valid_streams": {
+			expectedCfg: &Config{
+				Upstreams: map[string]*Upstream{
+					"postgres": &Upstream{Balancer: "least_conn", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "db1.foo.bar:5432", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}, &UpstreamServer{Path: "db2.foo.bar:5432", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+					"redis":    &Upstream{Balancer: "round_robin", Provider: "static", Transport: defaultTransport, Servers: []*UpstreamServer{&UpstreamServer{Path: "redis.foo.bar:6379", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}}},
+					"dns": &Upstream{
+						Balancer:  "round_robin",
+						Provider:  "static",
+						Transport: defaultTransport,
+						Servers:   []*UpstreamServer{&UpstreamServer{Path: "ns1.foo.bar:53", MaxFail: 10, FailTimeout: 1, MaxConns: 100, QueueSize: 100}},
+						HealthCheck: &HealthCheck{
+							Type:               "udp",
+							Send:               "ping",
+							Path:               "/",
+							Interval:           Duration{5 * time.Second},
+							Timeout:            Duration{time.Second},
+							HealthyThreshold:   2,
+							UnhealthyThreshold: 3,
+							ExpectedStatus:     "200-299",
+						},
+					},
+				},
+				Streams: []*Stream{
+					&Stream{Port: 5432, TCPPass: "postgres", ConnectTimeout: Duration{time.Second}, IdleTimeout: Duration{time.Hour}},
+					&Stream{Port: 6379, TCPPass: "redis", ConnectTimeout: Duration{5 * time.Second}, IdleTimeout: Duration{10 * time.Minute}},
+					&Stream{Port: 53, UDPPass: "dns", ConnectTimeout: Duration{5 * time.Second}, IdleTimeout: Duration{30 * time.Second}},
+				},
+			},
+		},
 	}
 
 	for name, c := range cases {
 		t.Run(name, func(t *testing.T) {
 			cfg, err := Parse(mustOpenConfigF(t, name))
 			assert.Equal(t, c.expectedCfg, cfg)
-			assert.Equal(t, c.expectedErr, err)
+			if c.expectedErr != nil {
+				// errors may be wrapped with details
+				assert.True(t, errors.Is(err, c.expectedErr), "%v", err)
+			} else {
+				assert.Nil(t, err)
+			}
 		})
 	}
 }
 
+func TestParseConfigProblems(t *testing.T) {
+	file := filepath.Join("testdata", "problems.toml")
+
+	_, err := ParseFile(file)
+
+	var ps Problems
+	if !errors.As(err, &ps) {
+		t.Fatalf("expected Problems, got %v", err)
+	}
+
+	want := []struct {
+		line int
+		key  string
+		err  error
+	}{
+		{3, "upstreams.backend.balancer", balancer.ErrUnknown},
+		{7, "upstreams.backend.servers[1].path", errNoServerPath},
+		{10, "upstreams.backend.helth_check", errUnknownKey},
+		{14, "upstreams.front.provider", provider.ErrUnknown},
+		{21, "server.locations[1]", errLocationPass},
+		{22, "server.locations[1].path", errLocationPath},
+		{23, "server.locations[1].http_pas", errUnknownKey},
+		{27, "server.locations[2].http_pass", errUpstreamMismatch},
+	}
+
+	if !assert.Equal(t, len(want), len(ps), "%v", err) {
+		return
+	}
+
+	for i, w := range want {
+		assert.Equal(t, file, ps[i].File)
+		assert.Equal(t, w.line, ps[i].Line, ps[i].Error())
+		assert.Equal(t, w.key, ps[i].Key)
+		assert.True(t, errors.Is(ps[i], w.err), ps[i].Error())
+	}
+
+	assert.Equal(t, file+":23: server.locations[1].http_pas: unknown key", ps[6].Error())
+}
+
+func TestParseConfigTypeProblems(t *testing.T) {
+	file := filepath.Join("testdata", "problems_types.toml")
+
+	_, err := ParseFile(file)
+
+	var ps Problems
+	if !errors.As(err, &ps) {
+		t.Fatalf("expected Problems, got %v", err)
+	}
+
+	want := []struct {
+		line int
+		key  string
+		err  error
+	}{
+		{4, "upstreams.backend.next_upstream", errInvalidType},
+		{8, "upstreams.backend.servers[0].weight", errInvalidType},
+		{11, "upstreams.backend.servers[1].max_conns", errNegativeLimit},
+		{12, "upstreams.backend.servers[1].queue_timeout", errInvalidValue},
+		{15, "upstreams.backend.health_check.interval", errInvalidType},
+		{18, "server.port", errInvalidType},
+	}
+
+	if !assert.Equal(t, len(want), len(ps), "%v", err) {
+		return
+	}
+
+	for i, w := range want {
+		assert.Equal(t, w.line, ps[i].Line, ps[i].Error())
+		assert.Equal(t, w.key, ps[i].Key)
+		assert.True(t, errors.Is(ps[i], w.err), ps[i].Error())
+	}
+
+	assert.Equal(t, file+":8: upstreams.backend.servers[0].weight: invalid value type: expected integer, got string", ps[1].Error())
+	assert.Equal(t, file+`:12: upstreams.backend.servers[1].queue_timeout: invalid value: time: invalid duration "abc"`, ps[3].Error())
+}
+
+func TestParseConfigDecodeProblem(t *testing.T) {
+	_, err := Parse(strings.NewReader("[server]\nport=80\nport=\"x\n"))
+
+	var ps Problems
+	if !errors.As(err, &ps) || len(ps) != 1 {
+		t.Fatalf("expected single problem, got %v", err)
+	}
+
+	assert.True(t, errors.Is(err, errInvalidTOML))
+	assert.Equal(t, 3, ps[0].Line)
+}
+
 func mustOpenConfigF(t *testing.T, fname string) io.Reader {
 	f, err := os.Open(filepath.Join("testdata", fname+".toml"))
 	if err != nil {
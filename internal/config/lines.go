@@ -0,0 +1,208 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var indexRe = regexp.MustCompile(`\[\d+\]`)
+
+// keyIndex maps key paths to lines of config file they are
+// defined on. Array of tables elements are indexed in paths
+// eg. upstreams.backend.servers[1].path
+type keyIndex struct {
+	lines map[string]int
+
+	// occurrences lists paths of each key
+	// in order of appearance, by unindexed path
+	occurrences map[string][]string
+
+	// arrays counts array of tables elements
+	arrays map[string]int
+}
+
+// indexKeys scans toml document for table headers and keys
+// It assumes the document has already been decoded successfully.
+func indexKeys(data []byte) *keyIndex {
+	idx := keyIndex{
+		lines:       make(map[string]int),
+		occurrences: make(map[string][]string),
+		arrays:      make(map[string]int),
+	}
+
+	var (
+		table string
+		// skip is closing delimiter of a multiline value
+		skip string
+		// depth is nesting of a multiline array
+		depth int
+	)
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+
+		if skip != "" {
+			if strings.Contains(line, skip) {
+				skip = ""
+			}
+			continue
+		}
+
+		if depth > 0 {
+			depth += bracketDepth(line)
+			continue
+		}
+
+		line = strings.TrimSpace(stripComment(line))
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "[["):
+			table = idx.resolve(splitKey(strings.TrimSuffix(line[2:], "]]")), true)
+			idx.add(table, n)
+		case strings.HasPrefix(line, "["):
+			table = idx.resolve(splitKey(strings.TrimSuffix(line[1:], "]")), false)
+			idx.add(table, n)
+		default:
+			i := indexOutside(line, '=')
+			if i < 0 {
+				continue
+			}
+			idx.add(joinKey(table, strings.Join(splitKey(line[:i]), ".")), n)
+
+			value := strings.TrimSpace(line[i+1:])
+			for _, q := range []string{`"""`, `'''`} {
+				if strings.HasPrefix(value, q) && !strings.Contains(value[3:], q) {
+					skip = q
+				}
+			}
+			if strings.HasPrefix(value, "[") {
+				depth = bracketDepth(value)
+			}
+		}
+	}
+
+	return &idx
+}
+
+// resolve returns indexed path of table header key
+func (idx *keyIndex) resolve(segments []string, array bool) string {
+	var path string
+	for i, s := range segments {
+		path = joinKey(path, s)
+		if array && i == len(segments)-1 {
+			n := idx.arrays[path]
+			idx.arrays[path] = n + 1
+			path += fmt.Sprintf("[%d]", n)
+			continue
+		}
+		if n, ok := idx.arrays[path]; ok {
+			path += fmt.Sprintf("[%d]", n-1)
+		}
+	}
+	return path
+}
+
+func (idx *keyIndex) add(path string, line int) {
+	if _, ok := idx.lines[path]; !ok {
+		idx.lines[path] = line
+	}
+	u := indexRe.ReplaceAllString(path, "")
+	idx.occurrences[u] = append(idx.occurrences[u], path)
+}
+
+// line returns line key is defined on, or line
+// of its closest parent if key is not defined
+func (idx *keyIndex) line(key string) int {
+	for key != "" {
+		if n, ok := idx.lines[key]; ok {
+			return n
+		}
+		i := strings.LastIndexAny(key, ".[")
+		if i < 0 {
+			return 0
+		}
+		key = key[:i]
+	}
+	return 0
+}
+
+func joinKey(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// splitKey splits dotted key into its unquoted parts
+func splitKey(key string) []string {
+	var parts []string
+	for {
+		i := indexOutside(key, '.')
+		if i < 0 {
+			break
+		}
+		parts = append(parts, unquote(key[:i]))
+		key = key[i+1:]
+	}
+	return append(parts, unquote(key))
+}
+
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// indexOutside returns index of the first c outside of quotes
+func indexOutside(s string, c byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == '\\' && quote == '"' {
+				i++
+			} else if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
+
+func stripComment(s string) string {
+	if i := indexOutside(s, '#'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// bracketDepth returns change of array nesting on line
+func bracketDepth(line string) int {
+	line = stripComment(line)
+	d := 0
+	for {
+		i := indexOutside(line, '[')
+		j := indexOutside(line, ']')
+		switch {
+		case i >= 0 && (j < 0 || i < j):
+			d++
+			line = line[i+1:]
+		case j >= 0:
+			d--
+			line = line[j+1:]
+		default:
+			return d
+		}
+	}
+}
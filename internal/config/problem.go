@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Problem represents an invalid config value
+type Problem struct {
+	File string
+	Line int
+
+	// Key is the path of the offending key
+	// eg. server.locations[0].http_pass
+	Key string
+	Err error
+}
+
+func (p *Problem) Error() string {
+	var parts []string
+	if p.File != "" {
+		parts = append(parts, p.File)
+	}
+	if p.Line > 0 {
+		parts = append(parts, strconv.Itoa(p.Line))
+	}
+	if p.Key != "" {
+		parts = append(parts, " "+p.Key)
+	}
+	parts = append(parts, " "+p.Err.Error())
+	return strings.TrimSpace(strings.Join(parts, ":"))
+}
+
+// Unwrap returns underlying error
+func (p *Problem) Unwrap() error { return p.Err }
+
+// Problems represents all problems found in config
+type Problems []*Problem
+
+func (ps Problems) Error() string {
+	var lines []string
+	for _, p := range ps {
+		lines = append(lines, p.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap returns errors of all problems
+// so that they can be matched by errors.Is
+func (ps Problems) Unwrap() []error {
+	var errs []error
+	for _, p := range ps {
+		errs = append(errs, p)
+	}
+	return errs
+}
+
+func (ps *Problems) add(key string, err error) {
+	*ps = append(*ps, &Problem{Key: key, Err: err})
+}
+
+// locate sets file and line of problems and sorts them by line,
+// problems of missing keys are located at their parent tables
+func (ps Problems) locate(file string, idx *keyIndex) {
+	for _, p := range ps {
+		p.File = file
+		if p.Line == 0 {
+			p.Line = idx.line(p.Key)
+		}
+	}
+	sort.SliceStable(ps, func(i, j int) bool {
+		return ps[i].Line < ps[j].Line
+	})
+}
+
+var nearLineRe = regexp.MustCompile(`^Near line (\d+)`)
+
+// decodeProblem returns problem of toml decode error
+func decodeProblem(err error) *Problem {
+	p := Problem{Err: fmt.Errorf("%w: %v", errInvalidTOML, err)}
+	if m := nearLineRe.FindStringSubmatch(err.Error()); m != nil {
+		p.Line, _ = strconv.Atoi(m[1])
+	}
+	return &p
+}
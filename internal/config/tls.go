@@ -0,0 +1,412 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// HTTP01Challenge represents ACME http-01 challenge config label
+	HTTP01Challenge = "http-01"
+
+	// TLSALPN01Challenge represents ACME tls-alpn-01 challenge config label
+	TLSALPN01Challenge = "tls-alpn-01"
+)
+
+const (
+	// ClientAuthRequire represents required client certificate config label
+	ClientAuthRequire = "require"
+
+	// ClientAuthVerifyIfGiven represents optional client
+	// certificate verified if sent config label
+	ClientAuthVerifyIfGiven = "verify_if_given"
+)
+
+var (
+	errNoTLSCert     = errors.New("tls cert_file and key_file must be set")
+	errTLSCert       = errors.New("unable to load tls certificate")
+	errTLSMinVersion = errors.New("tls min_version must be one of 1.0, 1.1, 1.2 or 1.3")
+	errTLSCipher     = errors.New("unknown tls cipher suite")
+	errRedirectPort  = errors.New("tls redirect_port must differ from server port")
+
+	errACMEDomains   = errors.New("tls acme domains must be a list of host names, wildcards are not supported")
+	errACMEDirectory = errors.New("tls acme directory_url must be an absolute http or https url")
+	errACMEChallenge = errors.New("tls acme challenges must be http-01 or tls-alpn-01")
+
+	errClientCA       = errors.New("tls client_ca_file must contain PEM encoded certificates")
+	errClientAuth     = errors.New("tls client_auth must be require or verify_if_given")
+	errClientAuthNoCA = errors.New("tls client_auth requires client_ca_file")
+
+	errUpstreamScheme     = errors.New("upstream scheme must be http or https")
+	errUpstreamTLS        = errors.New("upstream ca_file, client_cert_file, client_key_file, server_name and insecure_skip_verify require https scheme")
+	errUpstreamCA         = errors.New("upstream ca_file must contain PEM encoded certificates")
+	errUpstreamClientCert = errors.New("upstream client_cert_file and client_key_file must be a valid key pair")
+)
+
+const (
+	defaultTLSVersion     = "1.2"
+	defaultReloadInterval = time.Minute
+
+	defaultACMEDirectory   = "https://acme-v02.api.letsencrypt.org/directory"
+	defaultACMEStorageDir  = "/var/lib/gourmet/acme"
+	defaultACMERenewBefore = 30 * 24 * time.Hour
+)
+
+var hostnameRe = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLS represents server tls config resource
+type TLS struct {
+	// CertFile and KeyFile set the default certificate
+	// served to clients whose SNI matches no other certificate
+	CertFile   string `toml:"cert_file"`
+	KeyFile    string `toml:"key_file"`
+	MinVersion string `toml:"min_version"`
+
+	// Certificates lists additional certificates
+	// selected by SNI against their DNS names
+	Certificates []*TLSCertificate
+
+	// ReloadInterval sets how often certificate
+	// files are checked for changes
+	ReloadInterval Duration `toml:"reload_interval"`
+
+	// Ciphers lists enabled cipher suites by their names
+	// eg. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, go defaults
+	// are used if empty. TLS 1.3 suites are not configurable.
+	Ciphers []string
+
+	// RedirectPort enables plain http listener
+	// redirecting all requests to https
+	RedirectPort int `toml:"redirect_port"`
+
+	// ACME enables certificates obtained automatically
+	ACME *ACME `toml:"acme"`
+
+	// ClientCAFile enables client certificate authentication,
+	// certificates are verified against CAs it contains
+	ClientCAFile string `toml:"client_ca_file"`
+
+	// ClientAuth is either require (default) or verify_if_given
+	// in which case locations may require certificates by
+	// setting client_cert
+	ClientAuth string `toml:"client_auth"`
+
+	// ClientCertHeaders sets headers verified client
+	// certificate details are passed to upstreams in
+	ClientCertHeaders *ClientCertHeaders `toml:"client_cert_headers"`
+}
+
+// ClientCertHeaders represents client certificate headers config
+// resource, details with no header name set are not passed
+type ClientCertHeaders struct {
+	Subject     string
+	SANs        string `toml:"sans"`
+	Fingerprint string
+}
+
+// ACME represents automatic certificate config resource
+// A single certificate valid for all domains is obtained.
+type ACME struct {
+	Email   string
+	Domains []string
+
+	// DirectoryURL defaults to Let's Encrypt production
+	DirectoryURL string `toml:"directory_url"`
+
+	// StorageDir keeps account key and certificates
+	StorageDir string `toml:"storage_dir"`
+
+	// Challenges lists challenge types in order of preference.
+	// http-01 has to be served on port 80 (eg. by redirect_port
+	// listener), tls-alpn-01 on port 443.
+	Challenges []string
+
+	// RenewBefore sets how long before expiry certificate is renewed
+	RenewBefore Duration `toml:"renew_before"`
+}
+
+// TLSCertificate represents tls certificate and key file pair
+type TLSCertificate struct {
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+}
+
+// Pairs returns all certificates, default one first
+func (t *TLS) Pairs() []*TLSCertificate {
+	var pairs []*TLSCertificate
+	if t.CertFile != "" {
+		pairs = append(pairs, &TLSCertificate{CertFile: t.CertFile, KeyFile: t.KeyFile})
+	}
+	return append(pairs, t.Certificates...)
+}
+
+// ClientAuthType returns tls client authentication policy
+func (t *TLS) ClientAuthType() tls.ClientAuthType {
+	switch {
+	case t.ClientCAFile == "":
+		return tls.NoClientCert
+	case t.ClientAuth == ClientAuthVerifyIfGiven:
+		return tls.VerifyClientCertIfGiven
+	}
+	return tls.RequireAndVerifyClientCert
+}
+
+// ClientCertPool returns pool of client CA certificates
+func (t *TLS) ClientCertPool() (*x509.CertPool, error) {
+	data, err := os.ReadFile(t.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errClientCA, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errClientCA
+	}
+
+	return pool, nil
+}
+
+// Version returns tls min version
+func (t *TLS) Version() uint16 { return tlsVersions[t.MinVersion] }
+
+// CipherSuites returns ids of configured cipher suites
+func (t *TLS) CipherSuites() []uint16 {
+	var ids []uint16
+	for _, name := range t.Ciphers {
+		ids = append(ids, cipherSuite(name))
+	}
+	return ids
+}
+
+func (*Config) setTLSDefaults(t *TLS) {
+	if t.MinVersion == "" {
+		t.MinVersion = defaultTLSVersion
+	}
+	if t.ReloadInterval.Duration == 0 {
+		t.ReloadInterval.Duration = defaultReloadInterval
+	}
+	if t.ClientCAFile != "" {
+		if t.ClientAuth == "" {
+			t.ClientAuth = ClientAuthRequire
+		}
+		if t.ClientCertHeaders == nil {
+			t.ClientCertHeaders = &ClientCertHeaders{
+				Subject:     "X-Client-Cert-Subject",
+				SANs:        "X-Client-Cert-SANs",
+				Fingerprint: "X-Client-Cert-Fingerprint",
+			}
+		}
+	}
+	if a := t.ACME; a != nil {
+		if a.DirectoryURL == "" {
+			a.DirectoryURL = defaultACMEDirectory
+		}
+		if a.StorageDir == "" {
+			a.StorageDir = defaultACMEStorageDir
+		}
+		if len(a.Challenges) == 0 {
+			a.Challenges = []string{HTTP01Challenge, TLSALPN01Challenge}
+		}
+		if a.RenewBefore.Duration == 0 {
+			a.RenewBefore.Duration = defaultACMERenewBefore
+		}
+	}
+}
+
+func (t *TLS) validate(key string, port int, ps *Problems) {
+	if t.CertFile == "" && t.KeyFile == "" && len(t.Certificates) == 0 && t.ACME == nil {
+		ps.add(joinKey(key, "cert_file"), errNoTLSCert)
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		validatePair(key, t.CertFile, t.KeyFile, ps)
+	}
+
+	for i, c := range t.Certificates {
+		validatePair(fmt.Sprintf("%s.certificates[%d]", key, i), c.CertFile, c.KeyFile, ps)
+	}
+
+	if _, ok := tlsVersions[t.MinVersion]; !ok {
+		ps.add(joinKey(key, "min_version"), errTLSMinVersion)
+	}
+
+	for _, name := range t.Ciphers {
+		if cipherSuite(name) == 0 {
+			ps.add(joinKey(key, "ciphers"), fmt.Errorf("%w %q", errTLSCipher, name))
+		}
+	}
+
+	if t.RedirectPort == port {
+		ps.add(joinKey(key, "redirect_port"), errRedirectPort)
+	}
+
+	if t.ClientAuth != "" {
+		switch {
+		case t.ClientCAFile == "":
+			ps.add(joinKey(key, "client_auth"), errClientAuthNoCA)
+		case t.ClientAuth != ClientAuthRequire && t.ClientAuth != ClientAuthVerifyIfGiven:
+			ps.add(joinKey(key, "client_auth"), errClientAuth)
+		}
+	}
+
+	if t.ClientCAFile != "" {
+		if _, err := t.ClientCertPool(); err != nil {
+			ps.add(joinKey(key, "client_ca_file"), err)
+		}
+	}
+
+	if t.ACME != nil {
+		t.ACME.validate(joinKey(key, "acme"), ps)
+	}
+}
+
+func validatePair(key, certFile, keyFile string, ps *Problems) {
+	if certFile == "" || keyFile == "" {
+		ps.add(joinKey(key, "cert_file"), errNoTLSCert)
+		return
+	}
+
+	_, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		ps.add(joinKey(key, "cert_file"), fmt.Errorf("%w: %v", errTLSCert, err))
+	}
+}
+
+func (a *ACME) validate(key string, ps *Problems) {
+	if len(a.Domains) == 0 {
+		ps.add(joinKey(key, "domains"), errACMEDomains)
+	}
+
+	for _, d := range a.Domains {
+		if !hostnameRe.MatchString(d) {
+			ps.add(joinKey(key, "domains"), fmt.Errorf("%w: %q", errACMEDomains, d))
+		}
+	}
+
+	u, err := url.Parse(a.DirectoryURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		ps.add(joinKey(key, "directory_url"), errACMEDirectory)
+	}
+
+	for _, c := range a.Challenges {
+		if c != HTTP01Challenge && c != TLSALPN01Challenge {
+			ps.add(joinKey(key, "challenges"), fmt.Errorf("%w: %q", errACMEChallenge, c))
+		}
+	}
+}
+
+func cipherSuite(name string) uint16 {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == name {
+			return cs.ID
+		}
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		if cs.Name == name {
+			return cs.ID
+		}
+	}
+	return 0
+}
+
+// UpstreamTLS represents https upstream config resource
+type UpstreamTLS struct {
+	// CAFile verifies upstream server certificates
+	// instead of system root CAs
+	CAFile string `toml:"ca_file"`
+
+	// ClientCertFile and ClientKeyFile are presented
+	// to upstream servers requesting client certificates
+	ClientCertFile string `toml:"client_cert_file"`
+	ClientKeyFile  string `toml:"client_key_file"`
+
+	// ServerName overrides SNI and the name upstream
+	// server certificates are verified against
+	ServerName string `toml:"server_name"`
+
+	InsecureSkipVerify bool `toml:"insecure_skip_verify"`
+}
+
+// TLSConfig returns upstream client tls config,
+// nil if upstream scheme is not https
+func (t *Transport) TLSConfig() (*tls.Config, error) {
+	if t.Scheme != HTTPSScheme {
+		return nil, nil
+	}
+
+	tc := tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		data, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errUpstreamCA, err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(data) {
+			return nil, errUpstreamCA
+		}
+	}
+
+	if t.ClientCertFile != "" || t.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.ClientCertFile, t.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errUpstreamClientCert, err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return &tc, nil
+}
+
+func (t *Transport) validate(key string, ps *Problems) {
+	switch t.Scheme {
+	case HTTPSScheme:
+		if _, err := t.TLSConfig(); err != nil {
+			ps.add(key, err)
+		}
+	case HTTPScheme:
+		if t.UpstreamTLS != (UpstreamTLS{}) {
+			ps.add(joinKey(key, "scheme"), errUpstreamTLS)
+		}
+	default:
+		ps.add(joinKey(key, "scheme"), fmt.Errorf("%w: %q", errUpstreamScheme, t.Scheme))
+	}
+}
+
+// setServerSchemes strips scheme (eg. http://) from server
+// paths, upstream scheme is set from it if not set explicitly
+func (u *Upstream) setServerSchemes() error {
+	for _, s := range u.Servers {
+		i := strings.Index(s.Path, "://")
+		if i < 0 {
+			continue
+		}
+
+		scheme := s.Path[:i]
+		if u.Scheme == "" {
+			u.Scheme = scheme
+		} else if u.Scheme != scheme {
+			return fmt.Errorf("%w: server path %q doesn't match upstream scheme %s", errUpstreamScheme, s.Path, u.Scheme)
+		}
+
+		s.Path = s.Path[i+3:]
+	}
+	return nil
+}
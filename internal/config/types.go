@@ -0,0 +1,193 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+var textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// checkTypes reports values of doc which can not be decoded
+// into the type of config field they are set to, and removes
+// them from doc so that the rest of it can still be decoded
+func checkTypes(doc map[string]interface{}, ps *Problems) {
+	checkTable("", doc, reflect.TypeOf(Config{}), ps)
+}
+
+// stripTypes decodes data which failed to decode with err
+// into v again, with values of invalid type removed. Problems
+// found are added to ps, err is returned if there are none.
+func stripTypes(data []byte, v interface{}, err error, ps *Problems) (toml.MetaData, error) {
+	var doc map[string]interface{}
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		return toml.MetaData{}, err
+	}
+
+	n := len(*ps)
+	checkTypes(doc, ps)
+	if len(*ps) == n {
+		return toml.MetaData{}, err
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(doc); err != nil {
+		return toml.MetaData{}, err
+	}
+
+	return toml.Decode(buf.String(), v)
+}
+
+func checkTable(key string, table map[string]interface{}, t reflect.Type, ps *Problems) {
+	for k, v := range table {
+		ft, ok := fieldType(t, k)
+		if !ok {
+			// unknown keys are reported as undecoded
+			continue
+		}
+		if !checkValue(joinKey(key, k), v, ft, ps) {
+			delete(table, k)
+		}
+	}
+}
+
+// checkValue reports whether v can be decoded into t
+func checkValue(key string, v interface{}, t reflect.Type, ps *Problems) bool {
+	if reflect.PtrTo(t).Implements(textUnmarshaler) {
+		s, ok := v.(string)
+		if !ok {
+			ps.add(key, typeErr("string", v))
+			return false
+		}
+		u := reflect.New(t).Interface().(encoding.TextUnmarshaler)
+		if err := u.UnmarshalText([]byte(s)); err != nil {
+			ps.add(key, fmt.Errorf("%w: %v", errInvalidValue, err))
+			return false
+		}
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return checkValue(key, v, t.Elem(), ps)
+	case reflect.Struct:
+		table, ok := v.(map[string]interface{})
+		if !ok {
+			ps.add(key, typeErr("table", v))
+			return false
+		}
+		checkTable(key, table, t, ps)
+	case reflect.Map:
+		table, ok := v.(map[string]interface{})
+		if !ok {
+			ps.add(key, typeErr("table", v))
+			return false
+		}
+		for k, e := range table {
+			if !checkValue(joinKey(key, k), e, t.Elem(), ps) {
+				delete(table, k)
+			}
+		}
+	case reflect.Slice:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice {
+			ps.add(key, typeErr("array", v))
+			return false
+		}
+		ok := true
+		for i := 0; i < rv.Len(); i++ {
+			ok = checkValue(fmt.Sprintf("%s[%d]", key, i), rv.Index(i).Interface(), t.Elem(), ps) && ok
+		}
+		// arrays of tables are kept so
+		// that their indexes do not shift
+		return ok || t.Elem().Kind() == reflect.Ptr || t.Elem().Kind() == reflect.Struct
+	case reflect.String:
+		return checkKind(key, v, "string", ps)
+	case reflect.Bool:
+		return checkKind(key, v, "boolean", ps)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return checkKind(key, v, "integer", ps)
+	case reflect.Float32, reflect.Float64:
+		if _, ok := v.(int64); ok {
+			return true
+		}
+		return checkKind(key, v, "float", ps)
+	}
+
+	return true
+}
+
+func checkKind(key string, v interface{}, want string, ps *Problems) bool {
+	if tomlType(v) != want {
+		ps.add(key, typeErr(want, v))
+		return false
+	}
+	return true
+}
+
+func typeErr(want string, v interface{}) error {
+	return fmt.Errorf("%w: expected %s, got %s", errInvalidType, want, tomlType(v))
+}
+
+// tomlType returns toml type name of decoded value v
+func tomlType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case bool:
+		return "boolean"
+	case time.Time:
+		return "datetime"
+	case map[string]interface{}:
+		return "table"
+	}
+	if reflect.ValueOf(v).Kind() == reflect.Slice {
+		return "array"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// fieldType returns type of struct t field which toml key
+// is decoded into, matching field names the way toml does
+func fieldType(t reflect.Type, key string) (reflect.Type, bool) {
+	var fold reflect.Type
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("toml"), ",")[0]; tag != "" {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		} else if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if ft, ok := fieldType(f.Type, key); ok {
+				return ft, true
+			}
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == key {
+			return f.Type, true
+		}
+		if fold == nil && strings.EqualFold(name, key) {
+			fold = f.Type
+		}
+	}
+
+	return fold, fold != nil
+}
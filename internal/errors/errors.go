@@ -3,11 +3,12 @@ package errors
 
 import (
 	"fmt"
+	"net/http"
 )
 
 // New creates new gourmet error
 func New(status int, text, desc string) *Error {
-	return &Error{status, text, desc}
+	return &Error{Status: status, StatusText: text, Description: desc}
 }
 
 // Error represents gourmet http error
@@ -15,6 +16,10 @@ type Error struct {
 	Status      int    `json:"status"`
 	StatusText  string `json:"status_text"`
 	Description string `json:"description"`
+
+	// Header holds optional headers sent
+	// along with the error eg. Retry-After
+	Header http.Header `json:"-"`
 }
 
 // Error returns error string
@@ -0,0 +1,166 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+// grpcServing represents SERVING
+// grpc.health.v1.HealthCheckResponse status
+const grpcServing = 1
+
+var (
+	// ErrNotServing is returned by grpc probe if upstream
+	// server reports a status other than SERVING
+	ErrNotServing = errors.New("grpc health check status not serving")
+)
+
+// NewGRPC creates new GRPC probe instance
+// service is the name of the checked service, empty
+// string checks the overall health of the server
+func NewGRPC(service string, opts ...Option) *GRPC {
+	cfg := newProbeConfig(opts)
+
+	var p http.Protocols
+	if cfg.tls != nil {
+		p.SetHTTP2(true)
+	} else {
+		p.SetUnencryptedHTTP2(true)
+	}
+
+	return &GRPC{
+		service: service,
+		scheme:  cfg.scheme(),
+		client: &http.Client{
+			Transport: &http.Transport{Protocols: &p, TLSClientConfig: cfg.tls},
+		},
+	}
+}
+
+// GRPC represents grpc health check probe
+// It calls grpc.health.v1.Health/Check over cleartext
+// http2 (h2c), or http2 over tls if enabled
+type GRPC struct {
+	service string
+	scheme  string
+	client  *http.Client
+}
+
+// Probe probes upstream server at uri
+func (p *GRPC) Probe(ctx context.Context, uri string) error {
+	req, err := http.NewRequest(
+		"POST",
+		p.scheme+"://"+strings.TrimRight(uri, "/")+grpcHealthPath,
+		bytes.NewReader(grpcFrame(healthCheckRequest(p.service))),
+	)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("User-Agent", "gourmet-health-check")
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return err
+	}
+
+	// grpc-status is sent in headers for trailers only responses
+	st := resp.Trailer.Get("Grpc-Status")
+	if st == "" {
+		st = resp.Header.Get("Grpc-Status")
+	}
+	if st != "0" {
+		return fmt.Errorf("grpc status %s: %s", st, resp.Trailer.Get("Grpc-Message"))
+	}
+
+	msg, err := grpcMessage(body)
+	if err != nil {
+		return err
+	}
+
+	if healthCheckStatus(msg) != grpcServing {
+		return ErrNotServing
+	}
+
+	return nil
+}
+
+// healthCheckRequest encodes grpc.health.v1.HealthCheckRequest
+func healthCheckRequest(service string) []byte {
+	if service == "" {
+		return nil
+	}
+	b := []byte{0x0a}
+	b = binary.AppendUvarint(b, uint64(len(service)))
+	return append(b, service...)
+}
+
+// healthCheckStatus decodes status field of grpc.health.v1.HealthCheckResponse
+func healthCheckStatus(msg []byte) uint64 {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0
+		}
+		msg = msg[n:]
+
+		switch tag & 7 {
+		case 0:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0
+			}
+			if tag>>3 == 1 {
+				return v
+			}
+			msg = msg[n:]
+		case 2:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return 0
+			}
+			msg = msg[uint64(n)+l:]
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+func grpcFrame(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+func grpcMessage(b []byte) ([]byte, error) {
+	if len(b) < 5 {
+		return nil, fmt.Errorf("invalid grpc response frame")
+	}
+	l := binary.BigEndian.Uint32(b[1:5])
+	if uint32(len(b)-5) < l {
+		return nil, fmt.Errorf("invalid grpc response frame")
+	}
+	return b[5 : 5+l], nil
+}
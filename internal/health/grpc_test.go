@@ -0,0 +1,101 @@
+package health_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/health"
+)
+
+func TestGRPCProbe(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(grpcHealthHandler))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	defer srv.Close()
+
+	uri := strings.TrimPrefix(srv.URL, "http://")
+
+	cases := map[string]struct {
+		service string
+		wantErr bool
+	}{
+		"server serving":      {},
+		"service serving":     {service: "foo.Serving"},
+		"service not serving": {service: "foo.NotServing", wantErr: true},
+		"unknown service":     {service: "foo.Unknown", wantErr: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			err := health.NewGRPC(c.service).Probe(ctx, uri)
+			assert.Equal(t, c.wantErr, err != nil, "%v", err)
+		})
+	}
+}
+
+func TestGRPCProbeTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(grpcHealthHandler))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	uri := strings.TrimPrefix(srv.URL, "https://")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := health.NewGRPC("", health.WithTLS(&tls.Config{RootCAs: pool})).Probe(ctx, uri)
+	assert.NoError(t, err)
+}
+
+func grpcHealthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || r.URL.Path != "/grpc.health.v1.Health/Check" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	b, _ := ioutil.ReadAll(r.Body)
+
+	var service string
+	if len(b) > 7 {
+		service = string(b[7:])
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status")
+
+	var status byte
+	switch service {
+	case "", "foo.Serving":
+		status = 1
+	case "foo.NotServing":
+		status = 2
+	default:
+		// trailers only NOT_FOUND response
+		w.Header().Set("Grpc-Status", "5")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	msg := []byte{0x08, status}
+	frame := make([]byte, 5)
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	w.Write(append(frame, msg...))
+	w.Header().Set("Grpc-Status", "0")
+}
@@ -0,0 +1,57 @@
+// Package health provides active health check probes
+// for upstream servers
+package health
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnexpectedStatus is returned by a probe when upstream
+	// server responds with a status that is not expected
+	ErrUnexpectedStatus = errors.New("unexpected health check response status")
+)
+
+// Status represents a set of expected status code ranges
+type Status [][2]int
+
+// ParseStatus parses expected status spec which is a comma
+// separated list of codes or code ranges eg. "200-299" or "200,204,300-399"
+func ParseStatus(spec string) (Status, error) {
+	var st Status
+
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		bounds := strings.SplitN(p, "-", 2)
+
+		from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", p)
+		}
+
+		to := from
+		if len(bounds) == 2 {
+			to, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil || to < from {
+				return nil, fmt.Errorf("invalid status range %q", p)
+			}
+		}
+
+		st = append(st, [2]int{from, to})
+	}
+
+	return st, nil
+}
+
+// Match reports whether code is within expected status ranges
+func (st Status) Match(code int) bool {
+	for _, r := range st {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package health_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/health"
+)
+
+func TestParseStatus(t *testing.T) {
+	cases := map[string]struct {
+		spec    string
+		match   []int
+		nomatch []int
+		wantErr bool
+	}{
+		"range": {
+			spec:    "200-299",
+			match:   []int{200, 204, 299},
+			nomatch: []int{199, 300, 404},
+		},
+		"single": {
+			spec:    "204",
+			match:   []int{204},
+			nomatch: []int{200, 205},
+		},
+		"list": {
+			spec:    "200, 204,300-399",
+			match:   []int{200, 204, 301},
+			nomatch: []int{201, 404},
+		},
+		"invalid code":  {spec: "2xx", wantErr: true},
+		"invalid range": {spec: "299-200", wantErr: true},
+		"empty":         {spec: "", wantErr: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			st, err := health.ParseStatus(c.spec)
+			assert.Equal(t, c.wantErr, err != nil)
+			for _, code := range c.match {
+				assert.True(t, st.Match(code), "%d should match", code)
+			}
+			for _, code := range c.nomatch {
+				assert.False(t, st.Match(code), "%d should not match", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// NewHTTP creates new HTTP probe instance
+func NewHTTP(path string, expect Status, opts ...Option) *HTTP {
+	cfg := newProbeConfig(opts)
+
+	return &HTTP{
+		path:   "/" + strings.TrimLeft(path, "/"),
+		expect: expect,
+		scheme: cfg.scheme(),
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: cfg.tls},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// HTTP represents http health check probe
+// It issues a GET request to path and expects
+// the response status to match expected status
+type HTTP struct {
+	path   string
+	expect Status
+	scheme string
+	client *http.Client
+}
+
+// Probe probes upstream server at uri
+func (p *HTTP) Probe(ctx context.Context, uri string) error {
+	req, err := http.NewRequest("GET", p.scheme+"://"+strings.TrimRight(uri, "/")+p.path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Connection", "Close")
+	req.Header.Set("User-Agent", "gourmet-health-check")
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+
+	if !p.expect.Match(resp.StatusCode) {
+		return fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+
+	return nil
+}
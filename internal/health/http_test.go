@@ -0,0 +1,99 @@
+package health_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/health"
+)
+
+func TestHTTPProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusOK)
+		case "/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+		case "/redirect":
+			http.Redirect(w, r, "/healthz", http.StatusMovedPermanently)
+		case "/slow":
+			time.Sleep(50 * time.Millisecond)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	uri := strings.TrimPrefix(srv.URL, "http://")
+
+	cases := map[string]struct {
+		uri     string
+		path    string
+		expect  string
+		timeout time.Duration
+		wantErr bool
+	}{
+		"healthy":          {path: "/healthz", expect: "200-299"},
+		"path no slash":    {path: "healthz", expect: "200"},
+		"expected 204":     {path: "/nocontent", expect: "204"},
+		"unhealthy":        {path: "/down", expect: "200-299", wantErr: true},
+		"redirect":         {path: "/redirect", expect: "200-299", wantErr: true},
+		"expected 301":     {path: "/redirect", expect: "301"},
+		"timeout":          {path: "/slow", expect: "200", timeout: 10 * time.Millisecond, wantErr: true},
+		"connection error": {uri: "127.0.0.1:1", path: "/", expect: "200", wantErr: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			st, err := health.ParseStatus(c.expect)
+			assert.NoError(t, err)
+
+			timeout := c.timeout
+			if timeout == 0 {
+				timeout = time.Second
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			u := uri
+			if c.uri != "" {
+				u = c.uri
+			}
+
+			err = health.NewHTTP(c.path, st).Probe(ctx, u)
+			assert.Equal(t, c.wantErr, err != nil, "%v", err)
+		})
+	}
+}
+
+func TestHTTPProbeTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	uri := strings.TrimPrefix(srv.URL, "https://")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	st, _ := health.ParseStatus("200")
+
+	err := health.NewHTTP("/", st, health.WithTLS(&tls.Config{RootCAs: pool})).Probe(context.Background(), uri)
+	assert.NoError(t, err)
+
+	// untrusted server certificate
+	err = health.NewHTTP("/", st, health.WithTLS(&tls.Config{})).Probe(context.Background(), uri)
+	assert.Error(t, err)
+
+	// plain http to tls server
+	err = health.NewHTTP("/", st).Probe(context.Background(), uri)
+	assert.Error(t, err)
+}
@@ -0,0 +1,32 @@
+package health
+
+import "crypto/tls"
+
+// Option represents http and grpc probe option
+type Option func(*probeConfig)
+
+type probeConfig struct {
+	tls *tls.Config
+}
+
+// WithTLS makes probe connect to upstream servers over tls
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *probeConfig) {
+		c.tls = cfg
+	}
+}
+
+func newProbeConfig(opts []Option) probeConfig {
+	var c probeConfig
+	for _, o := range opts {
+		o(&c)
+	}
+	return c
+}
+
+func (c *probeConfig) scheme() string {
+	if c.tls != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,25 @@
+package health
+
+import (
+	"context"
+	"net"
+)
+
+// NewTCP creates new TCP probe instance
+func NewTCP() *TCP {
+	return &TCP{}
+}
+
+// TCP represents connect only health check probe
+type TCP struct {
+	dialer net.Dialer
+}
+
+// Probe probes upstream server at uri which should be in host:port form
+func (p *TCP) Probe(ctx context.Context, uri string) error {
+	conn, err := p.dialer.DialContext(ctx, "tcp", uri)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,53 @@
+package health_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/health"
+)
+
+func TestTCPProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	cases := map[string]struct {
+		uri     string
+		wantErr bool
+	}{
+		"listening":   {uri: l.Addr().String()},
+		"refused":     {uri: closed.Addr().String(), wantErr: true},
+		"invalid uri": {uri: "http://foo", wantErr: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			err := health.NewTCP().Probe(ctx, c.uri)
+			assert.Equal(t, c.wantErr, err != nil, "%v", err)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package health
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// NewUDP creates new UDP probe instance which sends
+// payload to the upstream server and expects a reply
+func NewUDP(payload string) *UDP {
+	return &UDP{payload: []byte(payload)}
+}
+
+// UDP represents request / reply datagram health check probe
+// Since UDP is connectionless a server is only considered
+// healthy if it replies to the probe datagram.
+type UDP struct {
+	payload []byte
+	dialer  net.Dialer
+}
+
+// Probe probes upstream server at uri which should be in host:port form
+func (p *UDP) Probe(ctx context.Context, uri string) error {
+	conn, err := p.dialer.DialContext(ctx, "udp", uri)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	} else {
+		conn.SetDeadline(time.Now().Add(time.Second))
+	}
+
+	_, err = conn.Write(p.payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Read(make([]byte, 1))
+	return err
+}
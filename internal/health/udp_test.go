@@ -0,0 +1,60 @@
+package health_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/health"
+)
+
+func TestUDPProbe(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	cases := map[string]struct {
+		uri     string
+		wantErr bool
+	}{
+		"reply":       {uri: echo.LocalAddr().String()},
+		"no reply":    {uri: silent.LocalAddr().String(), wantErr: true},
+		"refused":     {uri: closed.LocalAddr().String(), wantErr: true},
+		"invalid uri": {uri: "http://foo", wantErr: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := health.NewUDP("ping").Probe(ctx, c.uri)
+			assert.Equal(t, c.wantErr, err != nil, "%v", err)
+		})
+	}
+}
@@ -0,0 +1,446 @@
+// Package acme provides automatic tls certificates
+// obtained and renewed from an ACME (RFC 8555) CA
+// such as Let's Encrypt
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LetsEncryptURL is Let's Encrypt production directory url
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+const (
+	defaultStorageDir  = "/var/lib/gourmet/acme"
+	defaultRenewBefore = 30 * 24 * time.Hour
+
+	obtainTimeout = 10 * time.Minute
+	minRetry      = time.Minute
+	maxRetry      = 6 * time.Hour
+
+	// maxWait bounds the time between renewal checks
+	maxWait = 12 * time.Hour
+)
+
+var (
+	// ErrNoDomains is returned if manager is created without domains
+	ErrNoDomains = errors.New("acme: no domains configured")
+
+	errNoCertificate = errors.New("acme: certificate not obtained yet")
+)
+
+// New creates new certificate manager for domains
+// Account key and previously obtained certificate
+// are loaded from storage dir, or account key is
+// created if there is none.
+func New(domains []string, opts ...Option) (*Manager, error) {
+	if len(domains) == 0 {
+		return nil, ErrNoDomains
+	}
+
+	m := Manager{
+		domains:      domains,
+		directoryURL: LetsEncryptURL,
+		storageDir:   defaultStorageDir,
+		challenges:   []string{HTTP01, TLSALPN01},
+		renewBefore:  defaultRenewBefore,
+		httpClient:   http.DefaultClient,
+		pollInterval: time.Second,
+	}
+
+	for _, o := range opts {
+		o(&m)
+	}
+
+	if m.logger == nil {
+		m.logger = log.New(os.Stdout, "acme ", log.Ldate|log.Ltime)
+	}
+
+	err := os.MkdirAll(m.storageDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := m.accountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	m.client = &client{
+		http:         m.httpClient,
+		directoryURL: m.directoryURL,
+		key:          key,
+		pollInterval: m.pollInterval,
+	}
+
+	m.loadCert()
+
+	return &m, nil
+}
+
+// Manager obtains a single certificate valid
+// for all of its domains and keeps it renewed
+type Manager struct {
+	domains      []string
+	email        string
+	directoryURL string
+	storageDir   string
+	challenges   []string
+	renewBefore  time.Duration
+	httpClient   *http.Client
+	pollInterval time.Duration
+	fallback     func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	logger       *log.Logger
+
+	client *client
+	cert   atomic.Pointer[tls.Certificate]
+
+	// tokens maps http-01 tokens to key authorizations
+	tokens sync.Map
+
+	// alpnCerts maps domains to tls-alpn-01 certificates
+	alpnCerts sync.Map
+}
+
+// GetCertificate returns certificate for client hello
+// It is meant to be set as tls.Config GetCertificate.
+//
+// tls-alpn-01 validation requests are served challenge
+// certificates. Server names other than manager domains
+// are passed to fallback if set.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+
+	for _, p := range hello.SupportedProtos {
+		if p != ALPNProto {
+			continue
+		}
+		if c, ok := m.alpnCerts.Load(name); ok {
+			return c.(*tls.Certificate), nil
+		}
+		return nil, fmt.Errorf("acme: no tls-alpn-01 challenge for %q", name)
+	}
+
+	cert := m.cert.Load()
+
+	if m.fallback != nil && (cert == nil || !m.isDomain(name)) {
+		return m.fallback(hello)
+	}
+
+	if cert == nil {
+		return nil, errNoCertificate
+	}
+
+	return cert, nil
+}
+
+func (m *Manager) isDomain(name string) bool {
+	for _, d := range m.domains {
+		if strings.EqualFold(d, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run obtains the certificate if there is none
+// and renews it before it expires. Failed attempts
+// are retried with exponential backoff.
+// It is designed to be run async and closed by sending to c chan
+func (m *Manager) Run(c chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-c:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	retry := minRetry
+
+	for {
+		wait := m.renewIn()
+
+		if wait <= 0 {
+			err := m.obtain(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				m.logger.Printf("error obtaining certificate for %v: %v", m.domains, err)
+				wait = retry
+				retry *= 2
+				if retry > maxRetry {
+					retry = maxRetry
+				}
+			} else {
+				m.logger.Printf("obtained certificate for %v", m.domains)
+				retry = minRetry
+				continue
+			}
+		}
+
+		if wait > maxWait {
+			wait = maxWait
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// renewIn returns time left until certificate renewal
+// Certificates with lifetime shorter than renew before
+// duration are renewed after two thirds of their lifetime.
+func (m *Manager) renewIn() time.Duration {
+	cert := m.cert.Load()
+	if cert == nil {
+		return 0
+	}
+
+	before := m.renewBefore
+	if lifetime := cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore); before >= lifetime {
+		before = lifetime / 3
+	}
+
+	return time.Until(cert.Leaf.NotAfter.Add(-before))
+}
+
+// obtain orders new certificate and stores it
+func (m *Manager) obtain(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, obtainTimeout)
+	defer cancel()
+
+	err := m.client.register(ctx, m.email)
+	if err != nil {
+		return err
+	}
+
+	o, url, err := m.client.newOrder(ctx, m.domains)
+	if err != nil {
+		return err
+	}
+
+	for _, az := range o.Authorizations {
+		err = m.authorize(ctx, az)
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.domains[0]},
+		DNSNames: m.domains,
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.client.post(ctx, o.Finalize, map[string]string{"csr": b64(csr)}, o)
+	if err != nil {
+		return err
+	}
+
+	err = m.client.poll(ctx, url, o, func() string { return o.Status })
+	if err != nil {
+		return err
+	}
+
+	if o.Status != statusValid {
+		if o.Error != nil {
+			return o.Error
+		}
+		return fmt.Errorf("acme: order %s", o.Status)
+	}
+
+	chain, err := m.client.certificate(ctx, o.Certificate)
+	if err != nil {
+		return err
+	}
+
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	return m.storeCert(chain, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}))
+}
+
+// authorize completes authorization using the first
+// of configured challenge types offered by the CA
+func (m *Manager) authorize(ctx context.Context, url string) error {
+	var az authorization
+
+	_, err := m.client.post(ctx, url, nil, &az)
+	if err != nil {
+		return err
+	}
+
+	if az.Status == statusValid {
+		return nil
+	}
+
+	ch := m.pickChallenge(az.Challenges)
+	if ch == nil {
+		return fmt.Errorf("acme: no supported challenge offered for %s", az.Identifier.Value)
+	}
+
+	domain := az.Identifier.Value
+	keyAuth := m.client.keyAuth(ch.Token)
+
+	switch ch.Type {
+	case HTTP01:
+		m.tokens.Store(ch.Token, keyAuth)
+		defer m.tokens.Delete(ch.Token)
+	case TLSALPN01:
+		cert, err := alpnCert(domain, keyAuth)
+		if err != nil {
+			return err
+		}
+		m.alpnCerts.Store(domain, cert)
+		defer m.alpnCerts.Delete(domain)
+	}
+
+	_, err = m.client.post(ctx, ch.URL, struct{}{}, nil)
+	if err != nil {
+		return err
+	}
+
+	err = m.client.poll(ctx, url, &az, func() string { return az.Status })
+	if err != nil {
+		return err
+	}
+
+	if az.Status != statusValid {
+		for _, c := range az.Challenges {
+			if c.Error != nil {
+				return c.Error
+			}
+		}
+		return fmt.Errorf("acme: authorization for %s %s", domain, az.Status)
+	}
+
+	return nil
+}
+
+func (m *Manager) pickChallenge(offered []challenge) *challenge {
+	for _, t := range m.challenges {
+		for i := range offered {
+			if offered[i].Type == t {
+				return &offered[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Manager) accountKey() (*ecdsa.PrivateKey, error) {
+	file := filepath.Join(m.storageDir, "account.key")
+
+	data, err := os.ReadFile(file)
+	if err == nil {
+		b, _ := pem.Decode(data)
+		if b == nil {
+			return nil, fmt.Errorf("acme: invalid account key %s", file)
+		}
+		return x509.ParseECPrivateKey(b.Bytes)
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (m *Manager) certFiles() (string, string) {
+	base := filepath.Join(m.storageDir, m.domains[0])
+	return base + ".crt", base + ".key"
+}
+
+// loadCert loads stored certificate
+// if it is valid for all domains
+func (m *Manager) loadCert() {
+	cf, kf := m.certFiles()
+
+	cert, err := tls.LoadX509KeyPair(cf, kf)
+	if err != nil {
+		return
+	}
+
+	for _, d := range m.domains {
+		if cert.Leaf.VerifyHostname(d) != nil {
+			return
+		}
+	}
+
+	m.cert.Store(&cert)
+}
+
+func (m *Manager) storeCert(chain, key []byte) error {
+	cert, err := tls.X509KeyPair(chain, key)
+	if err != nil {
+		return err
+	}
+
+	cf, kf := m.certFiles()
+
+	err = os.WriteFile(kf, key, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(cf, chain, 0644)
+	if err != nil {
+		return err
+	}
+
+	m.cert.Store(&cert)
+
+	return nil
+}
@@ -0,0 +1,643 @@
+package acme_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/platform/acme"
+)
+
+func TestManager(t *testing.T) {
+	cases := map[string]struct {
+		challenges []string
+		badNonce   int
+		lifetime   time.Duration
+		httpServer func(*acme.Manager) http.Handler
+		assert     func(*testing.T, *fakeCA, *acme.Manager, *syncBuffer)
+	}{
+		"http-01": {
+			challenges: []string{acme.HTTP01},
+			assert: func(t *testing.T, ca *fakeCA, m *acme.Manager, _ *syncBuffer) {
+				cert := waitCert(t, m, "foo.com")
+				assert.Equal(t, []string{"foo.com", "www.foo.com"}, cert.Leaf.DNSNames)
+				assert.Equal(t, []string{acme.HTTP01}, ca.validated())
+			},
+		},
+		"tls-alpn-01": {
+			challenges: []string{acme.TLSALPN01, acme.HTTP01},
+			assert: func(t *testing.T, ca *fakeCA, m *acme.Manager, _ *syncBuffer) {
+				waitCert(t, m, "www.foo.com")
+				assert.Equal(t, []string{acme.TLSALPN01}, ca.validated())
+			},
+		},
+		"bad nonce retried": {
+			challenges: []string{acme.HTTP01},
+			badNonce:   1,
+			assert: func(t *testing.T, ca *fakeCA, m *acme.Manager, _ *syncBuffer) {
+				waitCert(t, m, "foo.com")
+			},
+		},
+		"failed challenge": {
+			challenges: []string{acme.HTTP01},
+			httpServer: func(*acme.Manager) http.Handler {
+				return http.NotFoundHandler()
+			},
+			assert: func(t *testing.T, ca *fakeCA, m *acme.Manager, logs *syncBuffer) {
+				waitFor(t, func() bool {
+					return strings.Contains(logs.String(), "error obtaining certificate")
+				})
+				assert.Contains(t, logs.String(), "urn:ietf:params:acme:error:unauthorized")
+
+				_, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "foo.com"})
+				assert.NotNil(t, err)
+			},
+		},
+		"renewal": {
+			challenges: []string{acme.HTTP01},
+			lifetime:   1500 * time.Millisecond,
+			assert: func(t *testing.T, ca *fakeCA, m *acme.Manager, _ *syncBuffer) {
+				first := waitCert(t, m, "foo.com")
+				waitFor(t, func() bool {
+					cert, _ := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "foo.com"})
+					return cert.Leaf.SerialNumber.Cmp(first.Leaf.SerialNumber) != 0
+				})
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ca := newFakeCA(t)
+			ca.badNonce = c.badNonce
+			if c.lifetime > 0 {
+				ca.lifetime = c.lifetime
+			}
+
+			logs := &syncBuffer{}
+
+			m, err := acme.New(
+				[]string{"foo.com", "www.foo.com"},
+				acme.WithEmail("admin@foo.com"),
+				acme.WithDirectoryURL(ca.srv.URL+"/dir"),
+				acme.WithStorageDir(t.TempDir()),
+				acme.WithChallenges(c.challenges...),
+				acme.WithPollInterval(10*time.Millisecond),
+				acme.WithLogger(log.New(logs, "", 0)),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var h http.Handler = m
+			if c.httpServer != nil {
+				h = c.httpServer(m)
+			}
+			hs := httptest.NewServer(h)
+			defer hs.Close()
+
+			ca.httpAddr = hs.Listener.Addr().String()
+			ca.alpnAddr = serveALPN(t, m)
+
+			q := make(chan struct{})
+			defer close(q)
+			go m.Run(q)
+
+			c.assert(t, ca, m, logs)
+		})
+	}
+}
+
+func TestManagerStorage(t *testing.T) {
+	ca := newFakeCA(t)
+	dir := t.TempDir()
+
+	m, err := acme.New(
+		[]string{"foo.com"},
+		acme.WithDirectoryURL(ca.srv.URL+"/dir"),
+		acme.WithStorageDir(dir),
+		acme.WithChallenges(acme.TLSALPN01),
+		acme.WithPollInterval(10*time.Millisecond),
+		acme.WithLogger(log.New(io.Discard, "", 0)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca.alpnAddr = serveALPN(t, m)
+
+	q := make(chan struct{})
+	go m.Run(q)
+	cert := waitCert(t, m, "foo.com")
+	close(q)
+
+	// stored certificate is served without contacting the CA
+	fallback := selfSigned(t, "other.com")
+	m, err = acme.New(
+		[]string{"foo.com"},
+		acme.WithDirectoryURL("http://127.0.0.1:1/dir"),
+		acme.WithStorageDir(dir),
+		acme.WithFallback(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return fallback, nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "foo.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Leaf.SerialNumber, got.Leaf.SerialNumber)
+
+	got, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, fallback, got)
+
+	// different domains require a new certificate
+	m, err = acme.New(
+		[]string{"foo.com", "bar.com"},
+		acme.WithStorageDir(dir),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "foo.com"})
+	assert.NotNil(t, err)
+
+	_, err = acme.New(nil)
+	assert.Equal(t, acme.ErrNoDomains, err)
+}
+
+func TestServeHTTP(t *testing.T) {
+	m, err := acme.New([]string{"foo.com"}, acme.WithStorageDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/", acme.ChallengePath + "unknown"} {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+}
+
+func waitCert(t *testing.T, m *acme.Manager, name string) *tls.Certificate {
+	var cert *tls.Certificate
+	waitFor(t, func() bool {
+		cert, _ = m.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		return cert != nil
+	})
+	return cert
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// serveALPN serves tls handshakes using manager certificates
+func serveALPN(t *testing.T, m *acme.Manager) string {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{acme.ALPNProto},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+type syncBuffer struct {
+	m sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.b.String()
+}
+
+// fakeCA implements the parts of ACME server
+// needed to issue certificates for a single account
+type fakeCA struct {
+	t        *testing.T
+	srv      *httptest.Server
+	httpAddr string
+	alpnAddr string
+	lifetime time.Duration
+	badNonce int
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	m       sync.Mutex
+	nonces  map[string]bool
+	key     *ecdsa.PublicKey
+	jwk     map[string]string
+	authz   map[string]*fakeAuthz
+	order   map[string]interface{}
+	checked []string
+	serial  int64
+}
+
+type fakeAuthz struct {
+	status string
+	token  string
+	err    map[string]string
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	ca := fakeCA{
+		t:        t,
+		lifetime: time.Hour,
+		nonces:   make(map[string]bool),
+		authz:    make(map[string]*fakeAuthz),
+	}
+
+	ca.caKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &ca.caKey.PublicKey, ca.caKey)
+	ca.caCert, _ = x509.ParseCertificate(der)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dir", func(w http.ResponseWriter, r *http.Request) {
+		u := ca.srv.URL
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   u + "/nonce",
+			"newAccount": u + "/new-account",
+			"newOrder":   u + "/new-order",
+		})
+	})
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", ca.newNonce())
+	})
+	mux.HandleFunc("/", ca.handle)
+
+	ca.srv = httptest.NewServer(mux)
+	t.Cleanup(ca.srv.Close)
+
+	return &ca
+}
+
+func (ca *fakeCA) newNonce() string {
+	ca.m.Lock()
+	defer ca.m.Unlock()
+	b := make([]byte, 8)
+	rand.Read(b)
+	n := base64.RawURLEncoding.EncodeToString(b)
+	ca.nonces[n] = true
+	return n
+}
+
+func (ca *fakeCA) validated() []string {
+	ca.m.Lock()
+	defer ca.m.Unlock()
+	return ca.checked
+}
+
+func (ca *fakeCA) problem(w http.ResponseWriter, status int, typ, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":   "urn:ietf:params:acme:error:" + typ,
+		"detail": detail,
+		"status": status,
+	})
+}
+
+func (ca *fakeCA) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", ca.newNonce())
+
+	payload, err := ca.verify(r)
+	if err != nil {
+		if err.Error() == "badNonce" {
+			ca.problem(w, http.StatusBadRequest, "badNonce", "bad nonce")
+			return
+		}
+		ca.problem(w, http.StatusUnauthorized, "malformed", err.Error())
+		return
+	}
+
+	ca.m.Lock()
+	defer ca.m.Unlock()
+
+	u := ca.srv.URL
+	path := r.URL.Path
+
+	switch {
+	case path == "/new-account":
+		w.Header().Set("Location", u+"/account")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "valid"})
+
+	case path == "/new-order":
+		var req struct {
+			Identifiers []struct{ Value string }
+		}
+		json.Unmarshal(payload, &req)
+
+		var azs, ids []interface{}
+		for _, id := range req.Identifiers {
+			ca.authz[id.Value] = &fakeAuthz{status: "pending", token: ca.token()}
+			azs = append(azs, u+"/authz/"+id.Value)
+			ids = append(ids, map[string]string{"type": "dns", "value": id.Value})
+		}
+		ca.order = map[string]interface{}{
+			"status":         "pending",
+			"identifiers":    ids,
+			"authorizations": azs,
+			"finalize":       u + "/finalize",
+		}
+		w.Header().Set("Location", u+"/order")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ca.order)
+
+	case strings.HasPrefix(path, "/authz/"):
+		domain := strings.TrimPrefix(path, "/authz/")
+		json.NewEncoder(w).Encode(ca.authzJSON(domain))
+
+	case strings.HasPrefix(path, "/chall/"):
+		parts := strings.SplitN(strings.TrimPrefix(path, "/chall/"), "/", 2)
+		typ, domain := parts[0], parts[1]
+		az := ca.authz[domain]
+		keyAuth := az.token + "." + ca.thumbprint()
+
+		ca.m.Unlock()
+		err := ca.validate(typ, domain, az.token, keyAuth)
+		ca.m.Lock()
+
+		ca.checked = append(ca.checked[:0:0], typ)
+		if err != nil {
+			az.status = "invalid"
+			az.err = map[string]string{
+				"type":   "urn:ietf:params:acme:error:unauthorized",
+				"detail": err.Error(),
+			}
+		} else {
+			az.status = "valid"
+		}
+		json.NewEncoder(w).Encode(map[string]string{"type": typ, "status": "processing"})
+
+	case path == "/finalize":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			ca.problem(w, http.StatusBadRequest, "badCSR", err.Error())
+			return
+		}
+		ca.order["certificate"] = u + "/cert"
+		ca.order["status"] = "processing"
+		ca.order["csr"] = csr
+		json.NewEncoder(w).Encode(ca.order)
+
+	case path == "/order":
+		if csr, ok := ca.order["csr"].(*x509.CertificateRequest); ok {
+			ca.order["chain"] = ca.issue(csr)
+			ca.order["status"] = "valid"
+			delete(ca.order, "csr")
+		}
+		w.Header().Set("Retry-After", "0")
+		json.NewEncoder(w).Encode(ca.order)
+
+	case path == "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.order["chain"].([]byte))
+
+	default:
+		ca.problem(w, http.StatusNotFound, "malformed", "not found")
+	}
+}
+
+func (ca *fakeCA) authzJSON(domain string) map[string]interface{} {
+	az := ca.authz[domain]
+	var challenges []interface{}
+	for _, typ := range []string{acme.HTTP01, acme.TLSALPN01} {
+		ch := map[string]interface{}{
+			"type":   typ,
+			"url":    ca.srv.URL + "/chall/" + typ + "/" + domain,
+			"token":  az.token,
+			"status": az.status,
+		}
+		if az.err != nil {
+			ch["error"] = az.err
+		}
+		challenges = append(challenges, ch)
+	}
+	return map[string]interface{}{
+		"status":     az.status,
+		"identifier": map[string]string{"type": "dns", "value": domain},
+		"challenges": challenges,
+	}
+}
+
+func (ca *fakeCA) validate(typ, domain, token, keyAuth string) error {
+	switch typ {
+	case acme.HTTP01:
+		req, _ := http.NewRequest("GET", "http://"+ca.httpAddr+acme.ChallengePath+token, nil)
+		req.Host = domain
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != keyAuth {
+			return fmt.Errorf("invalid key authorization %q", body)
+		}
+		return nil
+
+	case acme.TLSALPN01:
+		conn, err := tls.Dial("tcp", ca.alpnAddr, &tls.Config{
+			ServerName:         domain,
+			NextProtos:         []string{acme.ALPNProto},
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		cs := conn.ConnectionState()
+		if cs.NegotiatedProtocol != acme.ALPNProto {
+			return fmt.Errorf("protocol %q negotiated", cs.NegotiatedProtocol)
+		}
+
+		sum := sha256.Sum256([]byte(keyAuth))
+		for _, ext := range cs.PeerCertificates[0].Extensions {
+			if !ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}) {
+				continue
+			}
+			var v []byte
+			asn1.Unmarshal(ext.Value, &v)
+			if ext.Critical && bytes.Equal(v, sum[:]) {
+				return nil
+			}
+		}
+		return fmt.Errorf("acme identifier extension missing")
+	}
+
+	return fmt.Errorf("unknown challenge %s", typ)
+}
+
+func (ca *fakeCA) issue(csr *x509.CertificateRequest) []byte {
+	ca.serial++
+	tpl := x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(ca.lifetime),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, ca.caCert, csr.PublicKey, ca.caKey)
+	if err != nil {
+		ca.t.Error(err)
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})...)
+}
+
+func (ca *fakeCA) token() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (ca *fakeCA) thumbprint() string {
+	tp := fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":%q,"y":%q}`, ca.jwk["x"], ca.jwk["y"])
+	sum := sha256.Sum256([]byte(tp))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verify checks JWS nonce, url and signature and returns payload
+func (ca *fakeCA) verify(r *http.Request) ([]byte, error) {
+	var jws struct{ Protected, Payload, Signature string }
+	err := json.NewDecoder(r.Body).Decode(&jws)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var prot struct {
+		Alg   string
+		Nonce string
+		URL   string
+		Kid   string
+		JWK   map[string]string
+	}
+	err = json.Unmarshal(raw, &prot)
+	if err != nil {
+		return nil, err
+	}
+
+	ca.m.Lock()
+	defer ca.m.Unlock()
+
+	if !ca.nonces[prot.Nonce] {
+		return nil, fmt.Errorf("badNonce")
+	}
+	delete(ca.nonces, prot.Nonce)
+
+	if ca.badNonce > 0 {
+		ca.badNonce--
+		return nil, fmt.Errorf("badNonce")
+	}
+
+	if prot.Alg != "ES256" || prot.URL != ca.srv.URL+r.URL.Path {
+		return nil, fmt.Errorf("invalid protected header %s", raw)
+	}
+
+	switch {
+	case prot.JWK != nil && r.URL.Path == "/new-account":
+		x, _ := base64.RawURLEncoding.DecodeString(prot.JWK["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(prot.JWK["y"])
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, err
+		}
+		ca.key, ca.jwk = key, prot.JWK
+	case prot.Kid != ca.srv.URL+"/account" || ca.key == nil:
+		return nil, fmt.Errorf("unknown account %q", prot.Kid)
+	}
+
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if len(sig) != 64 {
+		return nil, fmt.Errorf("invalid signature length")
+	}
+	h := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if !ecdsa.Verify(ca.key, h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	return base64.RawURLEncoding.DecodeString(jws.Payload)
+}
+
+func selfSigned(t *testing.T, name string) *tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,86 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// HTTP01 represents http-01 challenge type
+	HTTP01 = "http-01"
+
+	// TLSALPN01 represents tls-alpn-01 challenge type
+	TLSALPN01 = "tls-alpn-01"
+
+	// ALPNProto is the protocol negotiated by tls-alpn-01
+	// validation requests, it has to be listed in tls
+	// config NextProtos for the challenge to succeed
+	ALPNProto = "acme-tls/1"
+
+	// ChallengePath is url path prefix of http-01 challenges
+	ChallengePath = "/.well-known/acme-challenge/"
+)
+
+// idPeACMEIdentifier is tls-alpn-01 certificate extension (RFC 8737)
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// ServeHTTP answers http-01 challenges
+// Requests outside of ChallengePath or for
+// unknown tokens are answered with not found.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, ChallengePath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	ka, ok := m.tokens.Load(strings.TrimPrefix(r.URL.Path, ChallengePath))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(ka.(string)))
+}
+
+// alpnCert creates self signed tls-alpn-01 challenge certificate
+func alpnCert(domain, keyAuth string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(keyAuth))
+	ext, err := asn1.Marshal(sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	tpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{Id: idPeACMEIdentifier, Critical: true, Value: ext},
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
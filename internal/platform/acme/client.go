@@ -0,0 +1,383 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	statusPending = "pending"
+	statusValid   = "valid"
+	statusInvalid = "invalid"
+
+	problemBadNonce = "urn:ietf:params:acme:error:badNonce"
+)
+
+// Problem represents ACME error document (RFC 7807)
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("acme: %s: %s", p.Type, p.Detail)
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *Problem     `json:"error"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Token  string   `json:"token"`
+	Status string   `json:"status"`
+	Error  *Problem `json:"error"`
+}
+
+// client implements ACME (RFC 8555) requests needed to
+// order certificates, signing them with account key
+type client struct {
+	http         *http.Client
+	directoryURL string
+	key          *ecdsa.PrivateKey
+	pollInterval time.Duration
+
+	m     sync.Mutex
+	dir   *directory
+	kid   string
+	nonce string
+}
+
+// register looks up the directory and creates
+// an account, or fetches existing one for the key
+func (c *client) register(ctx context.Context, email string) error {
+	c.m.Lock()
+	registered := c.kid != ""
+	c.m.Unlock()
+
+	if registered {
+		return nil
+	}
+
+	err := c.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	req := map[string]interface{}{"termsOfServiceAgreed": true}
+	if email != "" {
+		req["contact"] = []string{"mailto:" + email}
+	}
+
+	resp, err := c.post(ctx, c.dir.NewAccount, req, nil)
+	if err != nil {
+		return err
+	}
+
+	kid := resp.Header.Get("Location")
+	if kid == "" {
+		return fmt.Errorf("acme: account location missing")
+	}
+
+	c.m.Lock()
+	c.kid = kid
+	c.m.Unlock()
+
+	return nil
+}
+
+func (c *client) discover(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.directoryURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("acme: directory %s: %s", c.directoryURL, resp.Status)
+	}
+
+	var dir directory
+	err = json.NewDecoder(resp.Body).Decode(&dir)
+	if err != nil {
+		return err
+	}
+
+	c.dir = &dir
+
+	return nil
+}
+
+func (c *client) newOrder(ctx context.Context, domains []string) (*order, string, error) {
+	var ids []identifier
+	for _, d := range domains {
+		ids = append(ids, identifier{Type: "dns", Value: d})
+	}
+
+	var o order
+	resp, err := c.post(ctx, c.dir.NewOrder, map[string]interface{}{"identifiers": ids}, &o)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &o, resp.Header.Get("Location"), nil
+}
+
+// poll fetches url into v until status returns false
+func (c *client) poll(ctx context.Context, url string, v interface{}, status func() string) error {
+	for {
+		resp, err := c.post(ctx, url, nil, v)
+		if err != nil {
+			return err
+		}
+
+		if s := status(); s != statusPending && s != "processing" {
+			return nil
+		}
+
+		wait := c.pollInterval
+		if ra, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && ra > 0 {
+			wait = time.Duration(ra) * time.Second
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// certificate downloads PEM encoded certificate chain
+func (c *client) certificate(ctx context.Context, url string) ([]byte, error) {
+	var chain []byte
+	_, err := c.post(ctx, url, nil, &chain)
+	return chain, err
+}
+
+// post sends JWS signed payload to url, nil payload is sent
+// as POST-as-GET. Response is decoded into out if not nil,
+// *[]byte out receives raw response body.
+func (c *client) post(ctx context.Context, url string, payload interface{}, out interface{}) (*http.Response, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		body, err = json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.postJWS(ctx, url, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		p := c.problem(resp)
+		if p.Type != problemBadNonce {
+			return nil, p
+		}
+
+		// nonce expired, retry once with a fresh one
+		resp, err = c.postJWS(ctx, url, body)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 400 {
+			return nil, c.problem(resp)
+		}
+	}
+
+	switch v := out.(type) {
+	case nil:
+	case *[]byte:
+		*v, err = io.ReadAll(resp.Body)
+	default:
+		err = json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	return resp, err
+}
+
+func (c *client) problem(resp *http.Response) *Problem {
+	p := Problem{Status: resp.StatusCode}
+	err := json.NewDecoder(resp.Body).Decode(&p)
+	if err != nil || p.Type == "" {
+		p.Type = "about:blank"
+		p.Detail = resp.Status
+	}
+	return &p
+}
+
+func (c *client) postJWS(ctx context.Context, url string, payload []byte) (*http.Response, error) {
+	nonce, err := c.getNonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.sign(url, nonce, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.m.Lock()
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	c.m.Unlock()
+
+	return resp, nil
+}
+
+// getNonce returns nonce from the last response
+// or requests a new one if there is none
+func (c *client) getNonce(ctx context.Context) (string, error) {
+	c.m.Lock()
+	nonce := c.nonce
+	c.nonce = ""
+	c.m.Unlock()
+
+	if nonce != "" {
+		return nonce, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.dir.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	nonce = resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", fmt.Errorf("acme: no nonce returned by %s", c.dir.NewNonce)
+	}
+
+	return nonce, nil
+}
+
+// sign returns flattened JWS serialization signed with ES256
+func (c *client) sign(url, nonce string, payload []byte) ([]byte, error) {
+	prot := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": nonce,
+		"url":   url,
+	}
+
+	c.m.Lock()
+	if c.kid != "" {
+		prot["kid"] = c.kid
+	} else {
+		prot["jwk"] = jwk(c.key)
+	}
+	c.m.Unlock()
+
+	p, err := json.Marshal(prot)
+	if err != nil {
+		return nil, err
+	}
+
+	p64 := b64(p)
+	pl64 := b64(payload)
+
+	h := sha256.Sum256([]byte(p64 + "." + pl64))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, h[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return json.Marshal(struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}{p64, pl64, b64(sig)})
+}
+
+// keyAuth returns key authorization for challenge token
+func (c *client) keyAuth(token string) string {
+	j := jwk(c.key)
+	// members in lexicographic order (RFC 7638)
+	tp := fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, j["crv"], j["kty"], j["x"], j["y"])
+	h := crypto.SHA256.New()
+	h.Write([]byte(tp))
+	return token + "." + b64(h.Sum(nil))
+}
+
+func jwk(key *ecdsa.PrivateKey) map[string]string {
+	pub, _ := key.PublicKey.ECDH()
+	b := pub.Bytes() // uncompressed point 0x04 || x || y
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   b64(b[1:33]),
+		"y":   b64(b[33:65]),
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,79 @@
+package acme
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Option represents certificate manager option
+type Option func(*Manager)
+
+// WithEmail sets account contact email
+func WithEmail(email string) Option {
+	return func(m *Manager) {
+		m.email = email
+	}
+}
+
+// WithDirectoryURL sets CA directory url
+// Default is Let's Encrypt production directory.
+func WithDirectoryURL(url string) Option {
+	return func(m *Manager) {
+		m.directoryURL = url
+	}
+}
+
+// WithStorageDir sets directory account key
+// and obtained certificates are stored in
+func WithStorageDir(dir string) Option {
+	return func(m *Manager) {
+		m.storageDir = dir
+	}
+}
+
+// WithChallenges sets challenge types in order of preference
+// Default is http-01 followed by tls-alpn-01.
+func WithChallenges(types ...string) Option {
+	return func(m *Manager) {
+		m.challenges = types
+	}
+}
+
+// WithRenewBefore sets how long before expiry certificate is renewed
+func WithRenewBefore(d time.Duration) Option {
+	return func(m *Manager) {
+		m.renewBefore = d
+	}
+}
+
+// WithHTTPClient sets client used for CA requests
+func WithHTTPClient(c *http.Client) Option {
+	return func(m *Manager) {
+		m.httpClient = c
+	}
+}
+
+// WithPollInterval sets how often pending
+// authorizations and orders are checked
+func WithPollInterval(d time.Duration) Option {
+	return func(m *Manager) {
+		m.pollInterval = d
+	}
+}
+
+// WithFallback sets certificate source used for
+// server names other than manager domains
+func WithFallback(f func(*tls.ClientHelloInfo) (*tls.Certificate, error)) Option {
+	return func(m *Manager) {
+		m.fallback = f
+	}
+}
+
+// WithLogger sets manager logger
+func WithLogger(l *log.Logger) Option {
+	return func(m *Manager) {
+		m.logger = l
+	}
+}
@@ -0,0 +1,191 @@
+// Package certs provides tls certificate selection by SNI
+// and reloading of certificates changed on disk
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoCertificates is returned if store has no certificate pairs
+var ErrNoCertificates = errors.New("no tls certificates configured")
+
+// Pair represents certificate and private key file pair
+type Pair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// New creates new certificate store and loads all pairs
+//
+// First pair is the default certificate served to clients
+// not sending SNI or sending one no certificate matches.
+func New(pairs []Pair, opts ...Option) (*Store, error) {
+	if len(pairs) == 0 {
+		return nil, ErrNoCertificates
+	}
+
+	s := Store{pairs: pairs}
+
+	for _, o := range opts {
+		o(&s)
+	}
+
+	if s.logger == nil {
+		s.logger = log.New(os.Stdout, "certs ", log.Ldate|log.Ltime)
+	}
+
+	err := s.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Store represents a set of certificates selected by SNI
+// Certificates can be reloaded at any time, handshakes in
+// progress and established connections are not affected.
+type Store struct {
+	pairs  []Pair
+	logger *log.Logger
+	table  atomic.Pointer[table]
+}
+
+// table maps lowercase DNS names (including wildcards
+// eg. *.foo.com) to certificates
+type table struct {
+	names map[string]*tls.Certificate
+	def   *tls.Certificate
+}
+
+// GetCertificate selects certificate for client hello
+// It is meant to be set as tls.Config GetCertificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.table.Load().lookup(hello.ServerName), nil
+}
+
+func (t *table) lookup(name string) *tls.Certificate {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" {
+		return t.def
+	}
+
+	if c, ok := t.names[name]; ok {
+		return c
+	}
+
+	// wildcards match a single leftmost label only
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if c, ok := t.names["*"+name[i:]]; ok {
+			return c
+		}
+	}
+
+	return t.def
+}
+
+// Reload loads all certificate pairs from disk
+// If any of them fails to load previously
+// loaded certificates are kept.
+func (s *Store) Reload() error {
+	t := table{names: make(map[string]*tls.Certificate)}
+
+	for _, p := range s.pairs {
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return err
+		}
+
+		if cert.Leaf == nil {
+			cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return err
+			}
+		}
+
+		if t.def == nil {
+			t.def = &cert
+		}
+
+		// first listed certificate matching a name wins
+		for _, name := range certNames(cert.Leaf) {
+			if _, ok := t.names[name]; !ok {
+				t.names[name] = &cert
+			}
+		}
+	}
+
+	s.table.Store(&t)
+
+	return nil
+}
+
+func certNames(c *x509.Certificate) []string {
+	names := c.DNSNames
+	if len(names) == 0 && c.Subject.CommonName != "" {
+		names = []string{c.Subject.CommonName}
+	}
+
+	lower := make([]string, len(names))
+	for i, n := range names {
+		lower[i] = strings.ToLower(n)
+	}
+
+	return lower
+}
+
+// Watch reloads certificates every time any of the
+// files changes, checking them once per interval.
+// It is designed to be run async and closed by sending to c chan
+func (s *Store) Watch(interval time.Duration, c chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := s.modTimes()
+
+	for {
+		select {
+		case <-ticker.C:
+			mt := s.modTimes()
+			if mt == last {
+				continue
+			}
+
+			// cert and key may be replaced one at a time,
+			// failed reload is retried on next change check
+			err := s.Reload()
+			if err != nil {
+				s.logger.Printf("error reloading tls certificates: %v", err)
+				continue
+			}
+
+			s.logger.Println("tls certificates reloaded")
+			last = mt
+		case <-c:
+			return
+		}
+	}
+}
+
+// modTimes returns concatenated file modification times
+func (s *Store) modTimes() string {
+	var b strings.Builder
+	for _, p := range s.pairs {
+		for _, f := range []string{p.CertFile, p.KeyFile} {
+			fi, err := os.Stat(f)
+			if err != nil {
+				b.WriteString("-;")
+				continue
+			}
+			b.WriteString(fi.ModTime().String() + ";")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,185 @@
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/platform/certs"
+)
+
+func TestGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := certs.New([]certs.Pair{
+		writePair(t, dir, "default", "default.com"),
+		writePair(t, dir, "foo", "foo.com", "www.foo.com"),
+		writePair(t, dir, "wildcard", "*.bar.com"),
+		writePair(t, dir, "bar", "api.bar.com"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		serverName string
+		want       string
+	}{
+		"exact":             {serverName: "foo.com", want: "foo.com"},
+		"exact san":         {serverName: "www.foo.com", want: "foo.com"},
+		"case insensitive":  {serverName: "WWW.Foo.com", want: "foo.com"},
+		"trailing dot":      {serverName: "foo.com.", want: "foo.com"},
+		"wildcard":          {serverName: "www.bar.com", want: "*.bar.com"},
+		"exact over wild":   {serverName: "api.bar.com", want: "api.bar.com"},
+		"wildcard one deep": {serverName: "a.b.bar.com", want: "default.com"},
+		"wildcard no base":  {serverName: "bar.com", want: "default.com"},
+		"no match":          {serverName: "baz.com", want: "default.com"},
+		"no sni":            {serverName: "", want: "default.com"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: c.serverName})
+			assert.Nil(t, err)
+			assert.Equal(t, c.want, cert.Leaf.Subject.CommonName)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := certs.New(nil)
+	assert.Equal(t, certs.ErrNoCertificates, err)
+
+	_, err = certs.New([]certs.Pair{{CertFile: "none.crt", KeyFile: "none.key"}})
+	assert.NotNil(t, err)
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+
+	p := writePair(t, dir, "foo", "foo.com")
+
+	store, err := certs.New([]certs.Pair{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := serial(t, store, "foo.com")
+
+	writePair(t, dir, "foo", "foo.com")
+	assert.Nil(t, store.Reload())
+	assert.NotEqual(t, before, serial(t, store, "foo.com"))
+
+	// broken pair keeps previous certificates
+	reloaded := serial(t, store, "foo.com")
+	os.WriteFile(p.KeyFile, []byte("invalid"), 0600)
+	assert.NotNil(t, store.Reload())
+	assert.Equal(t, reloaded, serial(t, store, "foo.com"))
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+
+	p := writePair(t, dir, "foo", "foo.com")
+
+	store, err := certs.New(
+		[]certs.Pair{p},
+		certs.WithLogger(log.New(io.Discard, "", 0)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := make(chan struct{})
+	defer close(c)
+	go store.Watch(10*time.Millisecond, c)
+
+	before := serial(t, store, "foo.com")
+
+	// invalid key is not loaded, and is retried once fixed
+	os.WriteFile(p.KeyFile, []byte("invalid"), 0600)
+	touch(t, p.KeyFile, time.Now().Add(time.Second))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, before, serial(t, store, "foo.com"))
+
+	writePair(t, dir, "foo", "foo.com")
+	touch(t, p.CertFile, time.Now().Add(2*time.Second))
+	touch(t, p.KeyFile, time.Now().Add(2*time.Second))
+	time.Sleep(50 * time.Millisecond)
+	assert.NotEqual(t, before, serial(t, store, "foo.com"))
+}
+
+func serial(t *testing.T, s *certs.Store, name string) string {
+	cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.SerialNumber.String()
+}
+
+func touch(t *testing.T, f string, mt time.Time) {
+	if err := os.Chtimes(f, mt, mt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writePair writes self signed certificate for names
+// with a random serial number to dir/name.crt and dir/name.key
+func writePair(t *testing.T, dir, name string, names ...string) certs.Pair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sn, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := x509.Certificate{
+		SerialNumber: sn,
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := certs.Pair{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+
+	err = os.WriteFile(p.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(p.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
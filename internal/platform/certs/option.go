@@ -0,0 +1,13 @@
+package certs
+
+import "log"
+
+// Option represents certificate store option
+type Option func(*Store)
+
+// WithLogger sets store logger used to report reloads
+func WithLogger(l *log.Logger) Option {
+	return func(s *Store) {
+		s.logger = l
+	}
+}
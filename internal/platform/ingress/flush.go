@@ -0,0 +1,100 @@
+package ingress
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// flushInterval returns flush interval for resp
+func flushInterval(resp *http.Response, d time.Duration) time.Duration {
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if ct == "text/event-stream" {
+		return -1
+	}
+
+	if resp.ContentLength == -1 {
+		return -1
+	}
+
+	return d
+}
+
+func copyBody(w http.ResponseWriter, body io.Reader, interval time.Duration) error {
+	var dst io.Writer = w
+
+	if interval != 0 {
+		lw := &latencyWriter{
+			w:       w,
+			rc:      http.NewResponseController(w),
+			latency: interval,
+		}
+		defer lw.stop()
+		dst = lw
+	}
+
+	_, err := io.Copy(dst, body)
+	return err
+}
+
+// latencyWriter flushes written data at most latency
+// after it was written, or immediately if latency is negative
+type latencyWriter struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	latency time.Duration
+
+	m       sync.Mutex
+	t       *time.Timer
+	pending bool
+}
+
+func (lw *latencyWriter) Write(p []byte) (int, error) {
+	lw.m.Lock()
+	defer lw.m.Unlock()
+
+	n, err := lw.w.Write(p)
+
+	if lw.latency < 0 {
+		lw.rc.Flush()
+		return n, err
+	}
+
+	if lw.pending {
+		return n, err
+	}
+
+	if lw.t == nil {
+		lw.t = time.AfterFunc(lw.latency, lw.delayedFlush)
+	} else {
+		lw.t.Reset(lw.latency)
+	}
+	lw.pending = true
+
+	return n, err
+}
+
+func (lw *latencyWriter) delayedFlush() {
+	lw.m.Lock()
+	defer lw.m.Unlock()
+
+	// stop may have been called
+	if !lw.pending {
+		return
+	}
+
+	lw.rc.Flush()
+	lw.pending = false
+}
+
+func (lw *latencyWriter) stop() {
+	lw.m.Lock()
+	defer lw.m.Unlock()
+
+	lw.pending = false
+	if lw.t != nil {
+		lw.t.Stop()
+	}
+}
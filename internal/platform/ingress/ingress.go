@@ -4,23 +4,38 @@ package ingress
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/tonto/gourmet/internal/errors"
+	"github.com/tonto/gourmet/internal/platform/proxy"
 )
 
+// acmeChallengePath is url path prefix of ACME http-01 challenges
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
 // Ingress represents net/http ingress implementation
 type Ingress struct {
-	routes []*entry
+	routes atomic.Pointer[table]
 	logger *log.Logger
+	acme   http.Handler
 }
 
 type entry struct {
 	route   *route
 	handler ProtocolHandler
+	config  LocConfig
+}
+
+// LocConfig represents location configuration
+type LocConfig struct {
+	flushInterval time.Duration
+	idleTimeout   time.Duration
+	clientCert    bool
 }
 
 // ProtocolHandler represents an interface for protocol handlers
@@ -34,33 +49,30 @@ type ProtocolHandler interface {
 
 // New creates new http ingress instance
 func New(l *log.Logger) *Ingress {
-	return &Ingress{
-		logger: l,
-	}
+	igr := Ingress{logger: l}
+	igr.routes.Store(newTable())
+	return &igr
 }
 
 // ServeHTTP implements http.Handler
 func (igr *Ingress) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	igr.logger.Printf("%s %s IP: %s", r.Method, r.URL.Path, r.RemoteAddr)
 
-	ph, err := igr.match(r)
-	if err != nil {
-		igr.writeRouteErr(w, r)
+	if igr.acme != nil && strings.HasPrefix(r.URL.Path, acmeChallengePath) {
+		igr.acme.ServeHTTP(w, r)
 		return
 	}
 
-	igr.handleReq(w, r, ph)
-}
+	t := igr.acquire()
+	defer t.release()
 
-func (igr *Ingress) match(r *http.Request) (ProtocolHandler, error) {
-	for _, e := range igr.routes {
-		str := r.Host + r.URL.Path
-		if path, ok := e.route.match(str); ok {
-			r.URL.Path = "/" + path
-			return e.handler, nil
-		}
+	e, err := t.match(r)
+	if err != nil {
+		igr.writeRouteErr(w, r)
+		return
 	}
-	return nil, fmt.Errorf("no matching route")
+
+	igr.handleReq(w, r, e)
 }
 
 func (igr *Ingress) writeRouteErr(w http.ResponseWriter, r *http.Request) {
@@ -86,24 +98,83 @@ func (igr *Ingress) writeRouteErr(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (igr *Ingress) handleReq(w http.ResponseWriter, r *http.Request, ph ProtocolHandler) {
-	resp, err := ph.ServeRequest(r)
+func (igr *Ingress) handleReq(w http.ResponseWriter, r *http.Request, e *entry) {
+	if e.config.clientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		igr.writeErr(w, r, errors.New(
+			http.StatusForbidden,
+			http.StatusText(http.StatusForbidden),
+			"a verified client certificate is required",
+		))
+		return
+	}
+
+	resp, err := e.handler.ServeRequest(r)
 	select {
 	case <-r.Context().Done():
+		if err == nil && resp.Body != nil {
+			resp.Body.Close()
+		}
 		return
 	default:
 		if err != nil {
-			switch r.Header.Get("Accept") {
-			case "application/json":
-				igr.writerJSONErr(w, err)
-			default:
-				igr.writerTextErr(w, err)
-			}
+			igr.writeErr(w, r, err)
 			return
 		}
-		// TODO - write some headers also
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			igr.handleUpgrade(w, r, resp, e.config.idleTimeout)
+			return
+		}
+		igr.writeResponse(w, r, resp, e.config.flushInterval)
+	}
+}
+
+func (igr *Ingress) writeResponse(w http.ResponseWriter, r *http.Request, resp *http.Response, flush time.Duration) {
+	if resp.Body != nil {
 		defer resp.Body.Close()
-		io.Copy(w, resp.Body)
+	}
+
+	h := w.Header()
+	copyHeader(h, resp.Header)
+	proxy.RemoveHopHeaders(h)
+
+	announced := len(resp.Trailer)
+	if announced > 0 {
+		trailers := make([]string, 0, announced)
+		for k := range resp.Trailer {
+			trailers = append(trailers, k)
+		}
+		h.Add("Trailer", strings.Join(trailers, ", "))
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	if resp.Body == nil || !bodyAllowed(r.Method, resp.StatusCode) {
+		return
+	}
+
+	err := copyBody(w, resp.Body, flushInterval(resp, flush))
+	if err != nil {
+		igr.logger.Printf("error copying response body: %v", err)
+		return
+	}
+
+	// trailers not announced upfront have to be sent using TrailerPrefix
+	for k, vv := range resp.Trailer {
+		if len(resp.Trailer) != announced {
+			k = http.TrailerPrefix + k
+		}
+		for _, v := range vv {
+			h.Add(k, v)
+		}
+	}
+}
+
+func (igr *Ingress) writeErr(w http.ResponseWriter, r *http.Request, err error) {
+	switch r.Header.Get("Accept") {
+	case "application/json":
+		igr.writerJSONErr(w, err)
+	default:
+		igr.writerTextErr(w, err)
 	}
 }
 
@@ -123,6 +194,7 @@ func (igr *Ingress) writerJSONErr(w http.ResponseWriter, err error) {
 		return
 	}
 
+	copyHeader(w.Header(), ge.Header)
 	w.WriteHeader(ge.Status)
 	w.Write(data)
 }
@@ -137,6 +209,7 @@ func (igr *Ingress) writerTextErr(w http.ResponseWriter, err error) {
 		return
 	}
 
+	copyHeader(w.Header(), gerr.Header)
 	w.WriteHeader(gerr.Status)
 
 	err = writeErrTpl(w, gerr)
@@ -151,8 +224,21 @@ func (igr *Ingress) writeInternalErr(w http.ResponseWriter) {
 }
 
 // RegisterLocHandler registers location regex path with a location protocol handler
-func (igr *Ingress) RegisterLocHandler(pattern string, ph ProtocolHandler) {
-	igr.routes = append(igr.routes, &entry{route: &route{regexp.MustCompile(pattern)}, handler: ph})
+// It is not safe to call while serving, new routes are
+// registered on a new instance and swapped in instead.
+func (igr *Ingress) RegisterLocHandler(pattern string, ph ProtocolHandler, opts ...LocOption) {
+	e := entry{route: &route{regexp.MustCompile(pattern)}, handler: ph}
+	for _, o := range opts {
+		o(&e.config)
+	}
+	t := igr.routes.Load()
+	t.entries = append(t.entries, &e)
+}
+
+// RegisterACMEHandler registers handler answering ACME
+// http-01 challenges ahead of location matching
+func (igr *Ingress) RegisterACMEHandler(h http.Handler) {
+	igr.acme = h
 }
 
 type route struct {
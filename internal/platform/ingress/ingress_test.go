@@ -1,15 +1,20 @@
 package ingress
 
 import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tonto/gourmet/internal/errors"
@@ -21,10 +26,12 @@ func TestIngress(t *testing.T) {
 		url         string
 		body        string
 		json        bool
+		tls         *tls.ConnectionState
 		method      string
 		want        string
 		wantCode    int
 		wantContent string
+		wantHeaders map[string]string
 	}{
 		// Test r context timeout
 		// Test not found text resp
@@ -74,6 +81,16 @@ func TestIngress(t *testing.T) {
 			json:     false,
 			wantCode: http.StatusBadRequest,
 		},
+		{
+			name:     "test error headers",
+			method:   "GET",
+			url:      "http://api.foo.com/unavailable",
+			json:     true,
+			wantCode: http.StatusServiceUnavailable,
+			wantHeaders: map[string]string{
+				"Retry-After": "1",
+			},
+		},
 		{
 			name:     "test internal error",
 			method:   "GET",
@@ -89,6 +106,39 @@ func TestIngress(t *testing.T) {
 			json:     false,
 			wantCode: http.StatusNotFound,
 		},
+		{
+			name:     "test client cert required",
+			method:   "GET",
+			url:      "https://admin.foo.com/foo",
+			want:     `{"status":403,"status_text":"Forbidden","description":"a verified client certificate is required"}`,
+			json:     true,
+			tls:      &tls.ConnectionState{},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:   "test client cert verified",
+			method: "GET",
+			url:    "https://admin.foo.com/foo",
+			tls: &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}},
+			},
+			want:     "/foo",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "test acme challenge",
+			method:   "GET",
+			url:      "http://api.foo.com/.well-known/acme-challenge/token",
+			want:     "acme token",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "test acme challenge without location",
+			method:   "GET",
+			url:      "http://api.404.com/.well-known/acme-challenge/token",
+			want:     "acme token",
+			wantCode: http.StatusOK,
+		},
 	}
 	igr := makeigr()
 	for _, c := range cases {
@@ -105,12 +155,16 @@ func TestIngress(t *testing.T) {
 			if c.json {
 				r.Header.Add("Accept", "application/json")
 			}
+			r.TLS = c.tls
 			igr.ServeHTTP(w, r)
 			body, _ := ioutil.ReadAll(w.Body)
 			if c.want != "" {
 				assert.Equal(t, []byte(c.want), body)
 			}
 			assert.Equal(t, c.wantCode, w.Code)
+			for h, v := range c.wantHeaders {
+				assert.Equal(t, v, w.Header().Get(h))
+			}
 		})
 	}
 }
@@ -118,7 +172,11 @@ func TestIngress(t *testing.T) {
 func makeigr() *Ingress {
 	igr := New(log.New(os.Stdout, "ingress test => ", log.Ldate|log.Ltime|log.Lshortfile))
 	igr.RegisterLocHandler("api.foo.com/(.+)/?", phandler{})
+	igr.RegisterLocHandler("admin.foo.com/(.+)/?", phandler{}, WithClientCert())
 	igr.RegisterLocHandler("foo.com/(bar/.+/?)", phandler{})
+	igr.RegisterACMEHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "acme %s", strings.TrimPrefix(r.URL.Path, acmeChallengePath))
+	}))
 	return igr
 }
 
@@ -131,6 +189,10 @@ func (ph phandler) ServeRequest(r *http.Request) (*http.Response, error) {
 		return nil, errors.New(http.StatusBadRequest, http.StatusText(http.StatusBadRequest), r.URL.Path)
 	case "/internalerr":
 		return nil, fmt.Errorf("internal err")
+	case "/unavailable":
+		err := errors.New(http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable), r.URL.Path)
+		err.Header = http.Header{"Retry-After": []string{"1"}}
+		return nil, err
 	}
 
 	resp := http.Response{
@@ -161,3 +223,369 @@ func (rb rbody) Read(p []byte) (n int, err error) {
 }
 
 func (rb rbody) Close() error { return nil }
+
+func TestIngressResponse(t *testing.T) {
+	cases := map[string]struct {
+		method       string
+		resp         func() *http.Response
+		wantCode     int
+		wantBody     string
+		wantHeaders  map[string]string
+		wantTrailers map[string]string
+	}{
+		"status and headers": {
+			resp: func() *http.Response {
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Header: http.Header{
+						"Content-Type":  []string{"application/json"},
+						"Cache-Control": []string{"max-age=60"},
+						"Set-Cookie":    []string{"a=1", "b=2"},
+					},
+					Body: makeBody(`{"error":"upstream not found"}`),
+				}
+			},
+			wantCode: http.StatusNotFound,
+			wantBody: `{"error":"upstream not found"}`,
+			wantHeaders: map[string]string{
+				"Content-Type":  "application/json",
+				"Cache-Control": "max-age=60",
+			},
+		},
+		"redirect": {
+			resp: func() *http.Response {
+				return &http.Response{
+					StatusCode: http.StatusMovedPermanently,
+					Header:     http.Header{"Location": []string{"http://api.foo.com/bar"}},
+					Body:       makeBody(""),
+				}
+			},
+			wantCode:    http.StatusMovedPermanently,
+			wantHeaders: map[string]string{"Location": "http://api.foo.com/bar"},
+		},
+		"hop-by-hop headers": {
+			resp: func() *http.Response {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header: http.Header{
+						"Connection":   []string{"close, X-Hop"},
+						"X-Hop":        []string{"1"},
+						"Keep-Alive":   []string{"timeout=5"},
+						"Upgrade":      []string{"foo"},
+						"X-End-To-End": []string{"1"},
+					},
+					Body: makeBody("body"),
+				}
+			},
+			wantCode: http.StatusOK,
+			wantBody: "body",
+			wantHeaders: map[string]string{
+				"Connection":   "",
+				"X-Hop":        "",
+				"Keep-Alive":   "",
+				"Upgrade":      "",
+				"X-End-To-End": "1",
+			},
+		},
+		"trailers": {
+			resp: func() *http.Response {
+				resp := &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Trailer:    http.Header{"X-Checksum": nil},
+					Body:       makeBody("body"),
+				}
+				resp.Body = trailerBody{resp: resp, body: strings.NewReader("body")}
+				return resp
+			},
+			wantCode:     http.StatusOK,
+			wantBody:     "body",
+			wantTrailers: map[string]string{"X-Checksum": "abc"},
+		},
+		"no content": {
+			resp: func() *http.Response {
+				return &http.Response{
+					StatusCode: http.StatusNoContent,
+					Body:       makeBody("should not be sent"),
+				}
+			},
+			wantCode: http.StatusNoContent,
+		},
+		"not modified": {
+			resp: func() *http.Response {
+				return &http.Response{
+					StatusCode: http.StatusNotModified,
+					Header:     http.Header{"Etag": []string{`"v1"`}},
+					Body:       makeBody("should not be sent"),
+				}
+			},
+			wantCode:    http.StatusNotModified,
+			wantHeaders: map[string]string{"Etag": `"v1"`},
+		},
+		"head": {
+			method: "HEAD",
+			resp: func() *http.Response {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Length": []string{"4"}},
+					Body:       makeBody("body"),
+				}
+			},
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string]string{"Content-Length": "4"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			igr := New(log.New(ioutil.Discard, "", 0))
+			igr.RegisterLocHandler("api.foo.com/(.+)/?", phfunc(func(*http.Request) (*http.Response, error) {
+				return c.resp(), nil
+			}))
+
+			method := c.method
+			if method == "" {
+				method = "GET"
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, "http://api.foo.com/foo", nil)
+			igr.ServeHTTP(w, r)
+
+			res := w.Result()
+			body, _ := ioutil.ReadAll(res.Body)
+
+			assert.Equal(t, c.wantCode, res.StatusCode)
+			assert.Equal(t, c.wantBody, string(body))
+			for h, v := range c.wantHeaders {
+				assert.Equal(t, v, res.Header.Get(h), h)
+			}
+			for h, v := range c.wantTrailers {
+				assert.Equal(t, v, res.Trailer.Get(h), h)
+			}
+		})
+	}
+}
+
+type phfunc func(*http.Request) (*http.Response, error)
+
+func (f phfunc) ServeRequest(r *http.Request) (*http.Response, error) { return f(r) }
+
+// trailerBody sets response trailer values on EOF
+// the same way net/http client does
+type trailerBody struct {
+	resp *http.Response
+	body io.Reader
+}
+
+func (tb trailerBody) Read(p []byte) (int, error) {
+	n, err := tb.body.Read(p)
+	if err == io.EOF {
+		tb.resp.Trailer.Set("X-Checksum", "abc")
+	}
+	return n, err
+}
+
+func (tb trailerBody) Close() error { return nil }
+
+func TestIngressStreaming(t *testing.T) {
+	cases := map[string]struct {
+		header        http.Header
+		contentLength int64
+		flush         time.Duration
+	}{
+		"event stream": {
+			header:        http.Header{"Content-Type": []string{"text/event-stream; charset=utf-8"}},
+			contentLength: 0,
+		},
+		"chunked": {
+			header:        http.Header{"Content-Type": []string{"text/plain"}},
+			contentLength: -1,
+		},
+		"flush interval": {
+			header:        http.Header{"Content-Type": []string{"text/plain"}, "Content-Length": []string{"64"}},
+			contentLength: 64,
+			flush:         20 * time.Millisecond,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			pr, pw := io.Pipe()
+
+			igr := New(log.New(ioutil.Discard, "", 0))
+			igr.RegisterLocHandler("(.+)/?", phfunc(func(*http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode:    http.StatusOK,
+					Header:        c.header,
+					ContentLength: c.contentLength,
+					Body:          pr,
+				}, nil
+			}), WithFlushInterval(c.flush))
+
+			srv := httptest.NewServer(igr)
+			defer srv.Close()
+			defer pw.Close()
+
+			// body is left open so data can only be
+			// received if it has been flushed
+			go pw.Write([]byte("data: foo\n\n"))
+
+			res, err := http.Get(srv.URL + "/events")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			got := make(chan string)
+			go func() {
+				p := make([]byte, 11)
+				n, _ := io.ReadFull(res.Body, p)
+				got <- string(p[:n])
+			}()
+
+			select {
+			case data := <-got:
+				assert.Equal(t, "data: foo\n\n", data)
+			case <-time.After(time.Second):
+				t.Fatal("response data not flushed")
+			}
+		})
+	}
+}
+
+func TestIngressUpgrade(t *testing.T) {
+	cases := map[string]struct {
+		idle   time.Duration
+		assert func(*testing.T, net.Conn, *bufio.Reader, <-chan struct{})
+	}{
+		"echo": {
+			assert: func(t *testing.T, conn net.Conn, br *bufio.Reader, closed <-chan struct{}) {
+				for _, msg := range []string{"foo\n", "bar\n"} {
+					fmt.Fprint(conn, msg)
+					line, err := br.ReadString('\n')
+					assert.Nil(t, err)
+					assert.Equal(t, msg, line)
+				}
+			},
+		},
+		"client close": {
+			assert: func(t *testing.T, conn net.Conn, br *bufio.Reader, closed <-chan struct{}) {
+				conn.Close()
+				select {
+				case <-closed:
+				case <-time.After(time.Second):
+					t.Fatal("upstream connection not closed")
+				}
+			},
+		},
+		"idle timeout": {
+			idle: 50 * time.Millisecond,
+			assert: func(t *testing.T, conn net.Conn, br *bufio.Reader, closed <-chan struct{}) {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				_, err := br.ReadString('\n')
+				assert.Equal(t, io.EOF, err)
+				<-closed
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			closed := make(chan struct{})
+			ups := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, brw, err := http.NewResponseController(w).Hijack()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer close(closed)
+				defer conn.Close()
+
+				fmt.Fprint(brw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: "+r.Header.Get("Upgrade")+"\r\n\r\n")
+				brw.Flush()
+				io.Copy(conn, brw)
+			}))
+			defer ups.Close()
+
+			igr := New(log.New(ioutil.Discard, "", 0))
+			igr.RegisterLocHandler("(.+)/?", phfunc(func(r *http.Request) (*http.Response, error) {
+				req, _ := http.NewRequest("GET", ups.URL+r.URL.Path, nil)
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", r.Header.Get("Upgrade"))
+				return http.DefaultTransport.RoundTrip(req)
+			}), WithIdleTimeout(c.idle))
+
+			srv := httptest.NewServer(igr)
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			fmt.Fprint(conn, "GET /ws HTTP/1.1\r\nHost: foo\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n\r\n")
+
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+			assert.Equal(t, "websocket", resp.Header.Get("Upgrade"))
+
+			c.assert(t, conn, br, closed)
+		})
+	}
+}
+
+func TestIngressSwap(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	igr := New(logger)
+	igr.RegisterLocHandler("foo.com/(.*)", phfunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: makeBody("old")}, nil
+	}))
+
+	next := New(logger)
+	next.RegisterLocHandler("foo.com/(.*)", phfunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: makeBody("new")}, nil
+	}))
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		igr.ServeHTTP(w, httptest.NewRequest("GET", "http://foo.com"+path, nil))
+		return w.Body.String()
+	}
+
+	slow := make(chan string)
+	go func() { slow <- get("/slow") }()
+	<-started
+
+	drained := igr.Swap(next)
+
+	assert.Equal(t, "new", get("/"))
+
+	select {
+	case <-drained:
+		t.Fatal("drained with request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, "old", <-slow)
+
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("not drained")
+	}
+}
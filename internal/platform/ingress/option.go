@@ -0,0 +1,33 @@
+package ingress
+
+import "time"
+
+// LocOption represents location config option
+type LocOption func(*LocConfig)
+
+// WithFlushInterval sets the interval at which response body
+// is flushed to the client while being copied. Negative value
+// flushes after every write, zero disables periodic flushing.
+// Event streams and bodies of unknown length (eg. chunked) are
+// always flushed immediately.
+func WithFlushInterval(d time.Duration) LocOption {
+	return func(cfg *LocConfig) {
+		cfg.flushInterval = d
+	}
+}
+
+// WithIdleTimeout sets the time after which upgraded
+// (eg. websocket) connections with no traffic are closed
+func WithIdleTimeout(d time.Duration) LocOption {
+	return func(cfg *LocConfig) {
+		cfg.idleTimeout = d
+	}
+}
+
+// WithClientCert requires requests to present a verified
+// tls client certificate, others are rejected as forbidden
+func WithClientCert() LocOption {
+	return func(cfg *LocConfig) {
+		cfg.clientCert = true
+	}
+}
@@ -0,0 +1,87 @@
+package ingress
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// table represents location routes along with requests
+// matched against them which are still being served
+type table struct {
+	entries []*entry
+
+	m       sync.Mutex
+	active  int
+	retired bool
+	done    chan struct{}
+}
+
+func newTable() *table {
+	return &table{done: make(chan struct{})}
+}
+
+// acquire marks a request as served by t,
+// it returns false if t has been retired
+func (t *table) acquire() bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.retired {
+		return false
+	}
+	t.active++
+	return true
+}
+
+func (t *table) release() {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.active--
+	if t.retired && t.active == 0 {
+		close(t.done)
+	}
+}
+
+// retire returns a channel closed once
+// all acquired requests are released
+func (t *table) retire() <-chan struct{} {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if !t.retired {
+		t.retired = true
+		if t.active == 0 {
+			close(t.done)
+		}
+	}
+	return t.done
+}
+
+func (t *table) match(r *http.Request) (*entry, error) {
+	for _, e := range t.entries {
+		str := r.Host + r.URL.Path
+		if path, ok := e.route.match(str); ok {
+			r.URL.Path = "/" + path
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("no matching route")
+}
+
+// acquire returns current routes table
+// marking the request as served by it
+func (igr *Ingress) acquire() *table {
+	for {
+		t := igr.routes.Load()
+		if t.acquire() {
+			return t
+		}
+	}
+}
+
+// Swap atomically replaces location routes with the ones
+// registered on next. Returned channel is closed once all
+// requests matched against previous routes are finished,
+// after which their upstream servers can be stopped.
+func (igr *Ingress) Swap(next *Ingress) <-chan struct{} {
+	return igr.routes.Swap(next.routes.Load()).retire()
+}
@@ -0,0 +1,83 @@
+package ingress
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tonto/gourmet/internal/platform/proxy"
+)
+
+// defaultIdleTimeout is the time after which an idle
+// upgraded connection is closed if not configured
+const defaultIdleTimeout = 60 * time.Second
+
+// handleUpgrade hijacks client connection and pipes it to the
+// upgraded upstream connection returned as resp.Body until
+// either side closes or no data is transferred for idle time
+func (igr *Ingress) handleUpgrade(w http.ResponseWriter, r *http.Request, resp *http.Response, idle time.Duration) {
+	defer resp.Body.Close()
+
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		igr.logger.Printf("upgrade response body is not writable")
+		igr.writeInternalErr(w)
+		return
+	}
+
+	up := proxy.UpgradeType(resp.Header)
+	if !strings.EqualFold(up, proxy.UpgradeType(r.Header)) {
+		igr.logger.Printf("upstream switched to %q protocol, requested %q", up, proxy.UpgradeType(r.Header))
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		igr.logger.Printf("error hijacking connection: %v", err)
+		igr.writeInternalErr(w)
+		return
+	}
+	defer conn.Close()
+
+	h := make(http.Header)
+	copyHeader(h, resp.Header)
+	proxy.RemoveHopHeaders(h)
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", up)
+
+	res := http.Response{
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     h,
+	}
+	err = res.Write(brw)
+	if err == nil {
+		err = brw.Flush()
+	}
+	if err != nil {
+		igr.logger.Printf("error writing upgrade response: %v", err)
+		return
+	}
+
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+
+	// data already buffered by the server is read before the conn
+	proxy.Pipe(&bufferedConn{Conn: conn, r: brw}, backend, idle)
+}
+
+// bufferedConn reads from r instead of the underlying conn
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// ClientCertHeaders represents names of request headers verified
+// client certificate details are passed to upstream in.
+// Empty names are not set.
+type ClientCertHeaders struct {
+	// Subject receives certificate subject distinguished name
+	Subject string
+
+	// SANs receives comma separated subject alternative names
+	SANs string
+
+	// Fingerprint receives hex encoded sha256 of the certificate
+	Fingerprint string
+}
+
+// set sets client certificate headers on req
+// Headers sent by the client under the same names are
+// always removed so they can't be spoofed.
+func (ch *ClientCertHeaders) set(req *http.Request, r *http.Request) {
+	for _, name := range []string{ch.Subject, ch.SANs, ch.Fingerprint} {
+		if name != "" {
+			req.Header.Del(name)
+		}
+	}
+
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+
+	if ch.Subject != "" {
+		req.Header.Set(ch.Subject, cert.Subject.String())
+	}
+
+	if ch.SANs != "" {
+		sans := append([]string{}, cert.DNSNames...)
+		sans = append(sans, cert.EmailAddresses...)
+		for _, ip := range cert.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		for _, u := range cert.URIs {
+			sans = append(sans, u.String())
+		}
+		if len(sans) > 0 {
+			req.Header.Set(ch.SANs, strings.Join(sans, ","))
+		}
+	}
+
+	if ch.Fingerprint != "" {
+		sum := sha256.Sum256(cert.Raw)
+		req.Header.Set(ch.Fingerprint, hex.EncodeToString(sum[:]))
+	}
+}
@@ -0,0 +1,438 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/errors"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+// FastCGI record types and roles
+// (https://fastcgi-archives.github.io/FastCGI_Specification.html)
+const (
+	fcgiVersion      = 1
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+
+	fcgiResponder = 1
+	fcgiRequestID = 1
+
+	fcgiMaxContent = 65535
+	fcgiMaxPadding = 255
+)
+
+const defaultFastCGIIndex = "index.php"
+
+var defaultSplitPath = regexp.MustCompile(`^(.+?\.php)(/.*)$`)
+
+// NewFastCGI creates new FastCGI instance
+func NewFastCGI(bl balancer.Balancer, opts ...FastCGIOption) *FastCGI {
+	cfg := FastCGIConfig{
+		index:     defaultFastCGIIndex,
+		splitPath: defaultSplitPath,
+		stderr:    ioutil.Discard,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return &FastCGI{
+		balancer: bl,
+		config:   cfg,
+	}
+}
+
+// FastCGI represents fastcgi upstream pass (eg. php-fpm)
+// Upstream servers are tcp host:port pairs or unix
+// sockets given in unix:/path/to/socket form.
+type FastCGI struct {
+	balancer balancer.Balancer
+	config   FastCGIConfig
+}
+
+// FastCGIConfig represents fastcgi configuration
+type FastCGIConfig struct {
+	root         string
+	index        string
+	splitPath    *regexp.Regexp
+	params       map[string]string
+	stderr       io.Writer
+	hashKey      func(*http.Request) string
+	nextUpstream int
+
+	connectTimeout time.Duration
+	readTimeout    time.Duration
+}
+
+// ServeRequest passes request to upstream server
+func (fc *FastCGI) ServeRequest(r *http.Request) (*http.Response, error) {
+	if escapesRoot(r.URL.Path) {
+		return nil, errors.New(
+			http.StatusBadRequest,
+			http.StatusText(http.StatusBadRequest),
+			"request path is outside of document root",
+		)
+	}
+
+	var key string
+	if fc.config.hashKey != nil {
+		key = fc.config.hashKey(r)
+	}
+
+	return balance(fc.balancer, key, fc.config.nextUpstream, func(s *upstream.Server) (*http.Response, error) {
+		return serve(s, r, fc.pass)
+	})
+}
+
+func (fc *FastCGI) pass(c context.Context, uri string, r *http.Request) (*http.Response, error) {
+	network := "tcp"
+	if strings.HasPrefix(uri, "unix:") {
+		network, uri = "unix", strings.TrimPrefix(uri, "unix:")
+	}
+
+	d := net.Dialer{Timeout: fc.config.connectTimeout}
+	conn, err := d.DialContext(c, network, uri)
+	if err != nil {
+		return nil, badGateway(err)
+	}
+
+	if fc.config.readTimeout > 0 {
+		var cancel context.CancelFunc
+		c, cancel = context.WithTimeout(c, fc.config.readTimeout)
+		defer cancel()
+	}
+
+	// c only bounds the time until response headers are received
+	// while the connection lives as long as the client request
+	abort := context.AfterFunc(r.Context(), func() { conn.Close() })
+	timeout := context.AfterFunc(c, func() { conn.SetDeadline(time.Unix(1, 0)) })
+
+	resp, err := fc.roundTrip(conn, r)
+	if !timeout() && err == nil {
+		err = c.Err()
+	}
+	if err != nil {
+		abort()
+		conn.Close()
+		return nil, badGateway(err)
+	}
+
+	resp.Body = &body{
+		ReadCloser: ioutil.NopCloser(resp.Body),
+		cancel: func() {
+			abort()
+			conn.Close()
+		},
+	}
+
+	return resp, nil
+}
+
+func (fc *FastCGI) roundTrip(conn net.Conn, r *http.Request) (*http.Response, error) {
+	var stdin io.Reader = r.Body
+	length := r.ContentLength
+
+	// CONTENT_LENGTH is required so bodies
+	// of unknown length have to be buffered
+	if length < 0 {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		stdin, length = bytes.NewReader(b), int64(len(b))
+	}
+
+	w := bufio.NewWriter(conn)
+
+	begin := []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0}
+	err := writeRecord(w, fcgiBeginRequest, begin)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeStream(w, fcgiParams, bytes.NewReader(encodeParams(fc.params(r, length))))
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeStream(w, fcgiStdin, stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(&fcgiReader{r: bufio.NewReader(conn), stderr: fc.config.stderr})
+
+	h, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("invalid fastcgi response headers: %v", err)
+	}
+
+	return cgiResponse(http.Header(h), br)
+}
+
+// params returns CGI params for r
+func (fc *FastCGI) params(r *http.Request, length int64) map[string]string {
+	uri := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") && uri != "/" {
+		uri += "/"
+	}
+	if strings.HasSuffix(uri, "/") {
+		uri += fc.config.index
+	}
+
+	script, info := uri, ""
+	if m := fc.config.splitPath.FindStringSubmatch(uri); len(m) == 3 {
+		script, info = m[1], m[2]
+	}
+
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, port = r.Host, "80"
+		if r.TLS != nil {
+			port = "443"
+		}
+	}
+
+	raddr, rport, _ := net.SplitHostPort(r.RemoteAddr)
+
+	p := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "gourmet",
+		"SERVER_PROTOCOL":   r.Proto,
+		"SERVER_NAME":       host,
+		"SERVER_PORT":       port,
+		"REQUEST_METHOD":    r.Method,
+		"REQUEST_URI":       r.URL.RequestURI(),
+		"QUERY_STRING":      r.URL.RawQuery,
+		"DOCUMENT_ROOT":     fc.config.root,
+		"DOCUMENT_URI":      uri,
+		"SCRIPT_NAME":       script,
+		"SCRIPT_FILENAME":   fc.config.root + script,
+		"PATH_INFO":         info,
+		"REMOTE_ADDR":       raddr,
+		"REMOTE_PORT":       rport,
+		"CONTENT_TYPE":      r.Header.Get("Content-Type"),
+		"CONTENT_LENGTH":    strconv.FormatInt(length, 10),
+	}
+
+	if r.RequestURI != "" {
+		p["REQUEST_URI"] = r.RequestURI
+	}
+
+	if info != "" {
+		p["PATH_TRANSLATED"] = fc.config.root + info
+	}
+
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		p["SERVER_ADDR"], _, _ = net.SplitHostPort(addr.String())
+	}
+
+	if r.TLS != nil {
+		p["HTTPS"] = "on"
+	}
+
+	for k, v := range r.Header {
+		switch k {
+		// Proxy header is not passed (https://httpoxy.org)
+		case "Proxy", "Content-Type", "Content-Length":
+			continue
+		}
+		k = "HTTP_" + strings.ToUpper(strings.Replace(k, "-", "_", -1))
+		p[k] = strings.Join(v, ", ")
+	}
+
+	for k, v := range fc.config.params {
+		p[k] = v
+	}
+
+	return p
+}
+
+// escapesRoot reports whether p climbs above
+// document root with .. segments
+func escapesRoot(p string) bool {
+	rel := path.Clean("./" + p)
+	return rel == ".." || strings.HasPrefix(rel, "../")
+}
+
+// cgiResponse creates http response from CGI response headers
+func cgiResponse(h http.Header, body io.Reader) (*http.Response, error) {
+	resp := http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        h,
+		Body:          ioutil.NopCloser(body),
+		ContentLength: -1,
+	}
+
+	if st := h.Get("Status"); st != "" {
+		code, err := strconv.Atoi(strings.SplitN(st, " ", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid fastcgi response status %q", st)
+		}
+		resp.StatusCode = code
+		h.Del("Status")
+	} else if h.Get("Location") != "" {
+		resp.StatusCode = http.StatusFound
+	}
+
+	resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil {
+			resp.ContentLength = n
+		}
+	}
+
+	return &resp, nil
+}
+
+func writeRecord(w io.Writer, t byte, content []byte) error {
+	pad := (8 - len(content)%8) % 8
+
+	hdr := [8]byte{fcgiVersion, t}
+	binary.BigEndian.PutUint16(hdr[2:], fcgiRequestID)
+	binary.BigEndian.PutUint16(hdr[4:], uint16(len(content)))
+	hdr[6] = byte(pad)
+
+	_, err := w.Write(hdr[:])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(make([]byte, pad))
+	return err
+}
+
+// writeStream writes r as a stream of records
+// terminated by an empty record
+func writeStream(w io.Writer, t byte, r io.Reader) error {
+	buf := make([]byte, fcgiMaxContent)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if werr := writeRecord(w, t, buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return writeRecord(w, t, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func encodeParams(p map[string]string) []byte {
+	var buf bytes.Buffer
+	for k, v := range p {
+		encodeLen(&buf, len(k))
+		encodeLen(&buf, len(v))
+		buf.WriteString(k)
+		buf.WriteString(v)
+	}
+	return buf.Bytes()
+}
+
+func encodeLen(buf *bytes.Buffer, n int) {
+	if n < 128 {
+		buf.WriteByte(byte(n))
+		return
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n)|1<<31)
+	buf.Write(b[:])
+}
+
+// fcgiReader reads stdout stream of a fastcgi response
+// writing stderr stream to stderr
+type fcgiReader struct {
+	r      *bufio.Reader
+	stderr io.Writer
+	buf    [fcgiMaxContent + fcgiMaxPadding]byte
+	rest   []byte
+	done   bool
+}
+
+func (fr *fcgiReader) Read(p []byte) (int, error) {
+	for len(fr.rest) == 0 {
+		if fr.done {
+			return 0, io.EOF
+		}
+		err := fr.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, fr.rest)
+	fr.rest = fr.rest[n:]
+
+	return n, nil
+}
+
+func (fr *fcgiReader) next() error {
+	var hdr [8]byte
+
+	_, err := io.ReadFull(fr.r, hdr[:])
+	if err != nil {
+		return err
+	}
+
+	n := int(binary.BigEndian.Uint16(hdr[4:]))
+	content := fr.buf[:n+int(hdr[6])]
+
+	_, err = io.ReadFull(fr.r, content)
+	if err != nil {
+		return err
+	}
+	content = content[:n]
+
+	switch hdr[1] {
+	case fcgiStdout:
+		fr.rest = content
+	case fcgiStderr:
+		fr.stderr.Write(content)
+	case fcgiEndRequest:
+		fr.done = true
+		if n >= 5 && content[4] != 0 {
+			return fmt.Errorf("fastcgi request rejected with protocol status %d", content[4])
+		}
+	}
+
+	return nil
+}
+
+func badGateway(err error) error {
+	return errors.New(
+		http.StatusBadGateway,
+		http.StatusText(http.StatusBadGateway),
+		err.Error(),
+	)
+}
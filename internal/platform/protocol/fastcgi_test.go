@@ -0,0 +1,267 @@
+package protocol_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/errors"
+	"github.com/tonto/gourmet/internal/platform/protocol"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+func TestFastCGI(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env := fcgi.ProcessEnv(r)
+		switch r.URL.Query().Get("do") {
+		case "redirect":
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		case "fail":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Script-Filename", env["SCRIPT_FILENAME"])
+		// SCRIPT_NAME and PATH_INFO are consumed by net/http/fcgi
+		w.Header().Set("X-Path-Translated", env["PATH_TRANSLATED"])
+		w.Header().Set("X-Document-Root", env["DOCUMENT_ROOT"])
+		w.Header().Set("X-Query-String", r.URL.RawQuery)
+		w.Header().Set("X-Tenant", r.Header.Get("X-Tenant"))
+		w.Header().Set("X-Proxy", r.Header.Get("Proxy"))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s", r.Method, b)
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go fcgi.Serve(l, handler)
+
+	dir, err := ioutil.TempDir("", "fcgi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "php-fpm.sock")
+	ul, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	go fcgi.Serve(ul, handler)
+
+	cases := map[string]struct {
+		uri         string
+		method      string
+		path        string
+		body        string
+		wantCode    int
+		wantBody    string
+		wantHeaders map[string]string
+		wantErr     int
+		wantFails   int
+	}{
+		"script and path info": {
+			uri:      l.Addr().String(),
+			method:   "POST",
+			path:     "/app/index.php/users/1?page=2",
+			body:     "name=foo",
+			wantCode: http.StatusCreated,
+			wantBody: "POST name=foo",
+			wantHeaders: map[string]string{
+				"X-Script-Filename": "/var/www/app/index.php",
+				"X-Path-Translated": "/var/www/users/1",
+				"X-Document-Root":   "/var/www",
+				"X-Query-String":    "page=2",
+				"X-Tenant":          "foo",
+				"X-Proxy":           "",
+			},
+		},
+		"index": {
+			uri:      l.Addr().String(),
+			method:   "GET",
+			path:     "/app/",
+			wantCode: http.StatusCreated,
+			wantBody: "GET ",
+			wantHeaders: map[string]string{
+				"X-Script-Filename": "/var/www/app/index.php",
+				"X-Path-Translated": "",
+			},
+		},
+		"dot segments": {
+			uri:      l.Addr().String(),
+			method:   "GET",
+			path:     "/app/lib/../index.php/users/./1",
+			wantCode: http.StatusCreated,
+			wantBody: "GET ",
+			wantHeaders: map[string]string{
+				"X-Script-Filename": "/var/www/app/index.php",
+				"X-Path-Translated": "/var/www/users/1",
+			},
+		},
+		"unix socket": {
+			uri:      "unix:" + sock,
+			method:   "GET",
+			path:     "/info.php",
+			wantCode: http.StatusCreated,
+			wantBody: "GET ",
+			wantHeaders: map[string]string{
+				"X-Script-Filename": "/var/www/info.php",
+			},
+		},
+		"redirect": {
+			uri:         l.Addr().String(),
+			method:      "GET",
+			path:        "/index.php?do=redirect",
+			wantCode:    http.StatusFound,
+			wantBody:    "<a href=\"/login\">Found</a>.\n\n",
+			wantHeaders: map[string]string{"Location": "/login"},
+		},
+		"upstream error": {
+			uri:       l.Addr().String(),
+			method:    "GET",
+			path:      "/index.php?do=fail",
+			wantCode:  http.StatusServiceUnavailable,
+			wantFails: 1,
+		},
+		"upstream down": {
+			uri:     "unix:" + filepath.Join(dir, "none.sock"),
+			method:  "GET",
+			path:    "/index.php",
+			wantErr: http.StatusBadGateway,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := runServer(c.uri)
+			h := protocol.NewFastCGI(
+				&seqbl{servers: []*upstream.Server{s}},
+				protocol.WithFastCGIRoot("/var/www/"),
+			)
+
+			r := httptest.NewRequest(c.method, balancerPath+c.path, strings.NewReader(c.body))
+			r.Header.Set("X-Tenant", "foo")
+			r.Header.Set("Proxy", "http://evil")
+
+			resp, err := h.ServeRequest(r)
+			if c.wantErr != 0 {
+				if assert.NotNil(t, err) {
+					assert.Equal(t, c.wantErr, err.(*errors.Error).Status)
+				}
+				assert.Equal(t, 1, s.Fails())
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			b, _ := ioutil.ReadAll(resp.Body)
+
+			assert.Equal(t, c.wantCode, resp.StatusCode)
+			assert.Equal(t, c.wantBody, string(b))
+			for h, v := range c.wantHeaders {
+				assert.Equal(t, v, resp.Header.Get(h), h)
+			}
+			assert.Equal(t, "", resp.Header.Get("Status"))
+			assert.Equal(t, c.wantFails, s.Fails())
+		})
+	}
+}
+
+func TestFastCGITimeouts(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go fcgi.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		fmt.Fprint(w, "ok")
+	}))
+
+	cases := map[string]struct {
+		readTimeout time.Duration
+		wantErr     int
+		wantFails   int
+	}{
+		"slower than fail timeout": {
+			readTimeout: time.Second,
+		},
+		"read timeout": {
+			readTimeout: 50 * time.Millisecond,
+			wantErr:     http.StatusBadGateway,
+			wantFails:   1,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := upstream.NewServer(
+				l.Addr().String(),
+				upstream.WithFailTimeout(50*time.Millisecond),
+				upstream.WithMaxFail(10),
+				upstream.WithQueueSize(1),
+			)
+			cc := make(chan struct{})
+			go s.Run(cc)
+			defer func() { cc <- struct{}{} }()
+
+			h := protocol.NewFastCGI(
+				&seqbl{servers: []*upstream.Server{s}},
+				protocol.WithFastCGIConnectTimeout(time.Second),
+				protocol.WithFastCGIReadTimeout(c.readTimeout),
+			)
+
+			resp, err := h.ServeRequest(httptest.NewRequest("GET", balancerPath+"/index.php", nil))
+			if c.wantErr != 0 {
+				if assert.NotNil(t, err) {
+					assert.Equal(t, c.wantErr, err.(*errors.Error).Status)
+				}
+				assert.Equal(t, c.wantFails, s.Fails())
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			b, _ := ioutil.ReadAll(resp.Body)
+			assert.Equal(t, "ok", string(b))
+			assert.Equal(t, c.wantFails, s.Fails())
+		})
+	}
+}
+
+func TestFastCGIPathOutsideRoot(t *testing.T) {
+	s := runServer("127.0.0.1:0")
+	h := protocol.NewFastCGI(
+		&seqbl{servers: []*upstream.Server{s}},
+		protocol.WithFastCGIRoot("/var/www/"),
+	)
+
+	for _, p := range []string{"/../../../tmp/evil.php/info", "/app/../../evil.php"} {
+		r := httptest.NewRequest("GET", balancerPath+"/", nil)
+		r.URL.Path = p
+
+		_, err := h.ServeRequest(r)
+		if assert.NotNil(t, err, p) {
+			assert.Equal(t, http.StatusBadRequest, err.(*errors.Error).Status, p)
+		}
+		assert.Equal(t, 0, s.Fails(), p)
+	}
+}
@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/errors"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+// gRPC status codes used by the proxy
+// (https://github.com/grpc/grpc/blob/master/doc/statuscodes.md)
+const (
+	grpcUnknown          = 2
+	grpcDeadlineExceeded = 4
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcDataLoss         = 15
+	grpcUnauthenticated  = 16
+)
+
+// NewGRPC creates new GRPC instance
+// Requests are passed to upstream servers over cleartext
+// HTTP/2 (h2c) unless a transport is provided with options.
+func NewGRPC(bl balancer.Balancer, opts ...HTTPOption) *GRPC {
+	opts = append([]HTTPOption{WithHTTPTransport(h2cTransport())}, opts...)
+	opts = append(opts, func(cfg *Config) { cfg.grpc = true })
+
+	return &GRPC{
+		http: NewHTTP(bl, opts...),
+	}
+}
+
+// h2cTransport creates new transport which speaks
+// cleartext HTTP/2 with prior knowledge to upstream servers
+func h2cTransport() *http.Transport {
+	var p http.Protocols
+	p.SetUnencryptedHTTP2(true)
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Protocols = &p
+
+	return t
+}
+
+// GRPC represents grpc upstream pass
+// Requests and responses are streamed in both directions and
+// response trailers (eg. grpc-status) are passed to the client.
+// Responses with grpc-status indicating a server failure are
+// accounted for by upstream server passive health checks.
+type GRPC struct {
+	http *HTTP
+}
+
+// ServeRequest passes request to upstream server
+// Proxy errors are returned as gRPC status responses
+// so that they can be interpreted by grpc clients
+func (g *GRPC) ServeRequest(r *http.Request) (*http.Response, error) {
+	resp, err := g.http.ServeRequest(r)
+	if err != nil {
+		return grpcErrResponse(err), nil
+	}
+	return resp, nil
+}
+
+// observeGRPC records a server failure if grpc-status of
+// trailers only response or response trailers indicate one
+func observeGRPC(s *upstream.Server, resp *http.Response) {
+	if st := resp.Header.Get("Grpc-Status"); st != "" {
+		if grpcFailure(st) {
+			s.Fail()
+		}
+		return
+	}
+
+	resp.Body = &grpcBody{ReadCloser: resp.Body, resp: resp, server: s}
+}
+
+// grpcBody checks grpc-status trailer once body is read
+type grpcBody struct {
+	io.ReadCloser
+	resp   *http.Response
+	server *upstream.Server
+}
+
+func (b *grpcBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF && grpcFailure(b.resp.Trailer.Get("Grpc-Status")) {
+		b.server.Fail()
+	}
+	return n, err
+}
+
+func grpcFailure(status string) bool {
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return false
+	}
+	switch code {
+	case grpcUnknown, grpcInternal, grpcUnavailable, grpcDataLoss:
+		return true
+	}
+	return false
+}
+
+func grpcErrResponse(err error) *http.Response {
+	code := grpcInternal
+	msg := err.Error()
+
+	if ge, ok := err.(*errors.Error); ok {
+		code = grpcCode(ge.Status)
+		msg = ge.Description
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Content-Type": []string{"application/grpc"},
+			"Grpc-Status":  []string{strconv.Itoa(code)},
+			"Grpc-Message": []string{msg},
+		},
+		Body: http.NoBody,
+	}
+}
+
+// grpcCode maps http status to grpc status code
+// (https://github.com/grpc/grpc/blob/master/doc/http-grpc-status-mapping.md)
+func grpcCode(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable:
+		return grpcUnavailable
+	}
+	return grpcUnknown
+}
@@ -0,0 +1,209 @@
+package protocol_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/platform/protocol"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+func TestGRPC(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		if st := r.Header.Get("X-Trailers-Only"); st != "" {
+			w.Header().Set("Grpc-Status", st)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		// echo request stream line by line
+		br := bufio.NewReader(r.Body)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				break
+			}
+			fmt.Fprint(w, line)
+			w.(http.Flusher).Flush()
+		}
+
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", r.Header.Get("X-Status"))
+	}))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	cases := map[string]struct {
+		header    map[string]string
+		wantFails int
+	}{
+		"ok": {
+			header: map[string]string{"X-Status": "0"},
+		},
+		"client error": {
+			header: map[string]string{"X-Status": "5"},
+		},
+		"unavailable": {
+			header:    map[string]string{"X-Status": "14"},
+			wantFails: 1,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := runServer(ts.URL)
+			h := protocol.NewGRPC(&seqbl{servers: []*upstream.Server{s}})
+
+			pr, pw := io.Pipe()
+			r := httptest.NewRequest("POST", balancerPath+"/foo.Bar/Baz", pr)
+			r.Header.Set("Content-Type", "application/grpc")
+			r.Header.Set("Te", "trailers")
+			for k, v := range c.header {
+				r.Header.Set(k, v)
+			}
+
+			resp, err := h.ServeRequest(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			// messages are streamed in both directions
+			br := bufio.NewReader(resp.Body)
+			for _, msg := range []string{"foo\n", "bar\n"} {
+				fmt.Fprint(pw, msg)
+				line, err := br.ReadString('\n')
+				assert.Nil(t, err)
+				assert.Equal(t, msg, line)
+			}
+			pw.Close()
+
+			rest, _ := ioutil.ReadAll(br)
+			assert.Equal(t, "", string(rest))
+			assert.Equal(t, c.header["X-Status"], resp.Trailer.Get("Grpc-Status"))
+			assert.Equal(t, c.wantFails, s.Fails())
+		})
+	}
+}
+
+func TestGRPCSlowUpstream(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "foo\n")
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	// upstream response headers take longer than fail timeout
+	s := upstream.NewServer(
+		strings.TrimPrefix(ts.URL, "http://"),
+		upstream.WithFailTimeout(50*time.Millisecond),
+		upstream.WithMaxFail(1),
+		upstream.WithQueueSize(1),
+	)
+	c := make(chan struct{})
+	go s.Run(c)
+	defer func() { c <- struct{}{} }()
+
+	h := protocol.NewGRPC(&seqbl{servers: []*upstream.Server{s}})
+
+	r := httptest.NewRequest("POST", balancerPath+"/foo.Bar/Baz", strings.NewReader(""))
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("Te", "trailers")
+
+	resp, err := h.ServeRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Grpc-Status"))
+	assert.Equal(t, "foo\n", string(b))
+	assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+	assert.Equal(t, 0, s.Fails())
+}
+
+func TestGRPCErrors(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", "13")
+	}))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := l.Addr().String()
+	l.Close()
+
+	cases := map[string]struct {
+		uri        string
+		wantStatus string
+		wantFails  int
+	}{
+		"trailers only": {
+			uri:        ts.URL,
+			wantStatus: "13",
+			wantFails:  1,
+		},
+		"upstream down": {
+			uri:        "http://" + down,
+			wantStatus: "14",
+			wantFails:  1,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := runServer(c.uri)
+			h := protocol.NewGRPC(&seqbl{servers: []*upstream.Server{s}})
+
+			r := httptest.NewRequest("POST", balancerPath+"/foo.Bar/Baz", strings.NewReader(""))
+			r.Header.Set("Content-Type", "application/grpc")
+
+			resp, err := h.ServeRequest(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "application/grpc", resp.Header.Get("Content-Type"))
+			assert.Equal(t, c.wantStatus, resp.Header.Get("Grpc-Status"))
+			assert.Equal(t, c.wantFails, s.Fails())
+		})
+	}
+}
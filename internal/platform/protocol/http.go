@@ -2,24 +2,43 @@ package protocol
 
 import (
 	"context"
+	"io"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tonto/gourmet/internal/balancer"
 	"github.com/tonto/gourmet/internal/errors"
+	"github.com/tonto/gourmet/internal/platform/proxy"
 	"github.com/tonto/gourmet/internal/upstream"
 )
 
+// retryAfter is the Retry-After header value (in seconds)
+// sent when upstream server queues are full
+const retryAfter = "1"
+
 // NewHTTP creates new HTTP instance
 func NewHTTP(bl balancer.Balancer, opts ...HTTPOption) *HTTP {
-	cfg := Config{}
+	cfg := Config{scheme: "http"}
 	for _, o := range opts {
 		o(&cfg)
 	}
+	rt := cfg.transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
 	h := HTTP{
 		balancer: bl,
 		config:   cfg,
+		client: &http.Client{
+			Transport: rt,
+			// redirects are passed back to the client
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
 	}
 	return &h
 }
@@ -28,43 +47,36 @@ func NewHTTP(bl balancer.Balancer, opts ...HTTPOption) *HTTP {
 type HTTP struct {
 	balancer balancer.Balancer
 	config   Config
+	client   *http.Client
 }
 
 // Config represents http configuration
 type Config struct {
 	passHeaders    map[string]string
 	requestTimeout time.Duration
+	hashKey        func(*http.Request) string
+	nextUpstream   int
+	transport      http.RoundTripper
+	scheme         string
+	grpc           bool
+
+	clientCertHeaders *ClientCertHeaders
 }
 
 // ServeRequest passes request to upstream server
 func (ht *HTTP) ServeRequest(r *http.Request) (*http.Response, error) {
-	var response *http.Response
-
-	s, err := ht.balancer.NextServer()
-	if err != nil {
-		return nil, errors.New(
-			http.StatusServiceUnavailable,
-			http.StatusText(http.StatusServiceUnavailable),
-			err.Error(),
-		)
-	}
-
-	done := make(chan error)
-
-	s.Work <- upstream.Request{
-		Done: done,
-		F: func(c context.Context, uri string) error {
-			resp, err := ht.proxyPass(c, uri, r)
-			if err != nil {
-				return err
-			}
-			response = resp
-			return nil
-		},
+	var key string
+	if ht.config.hashKey != nil {
+		key = ht.config.hashKey(r)
 	}
 
-	err = <-done
-	return response, err
+	return balance(ht.balancer, key, ht.config.nextUpstream, func(s *upstream.Server) (*http.Response, error) {
+		resp, err := serve(s, r, ht.proxyPass)
+		if err == nil && ht.config.grpc {
+			observeGRPC(s, resp)
+		}
+		return resp, err
+	})
 }
 
 func (ht *HTTP) proxyPass(c context.Context, uri string, r *http.Request) (*http.Response, error) {
@@ -73,13 +85,23 @@ func (ht *HTTP) proxyPass(c context.Context, uri string, r *http.Request) (*http
 		return nil, err
 	}
 
-	client := http.Client{
-		Timeout: 10 * time.Second,
-		// TODO - Use client timeout from config
+	if ht.config.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		c, cancel = context.WithTimeout(c, ht.config.requestTimeout)
+		defer cancel()
 	}
 
-	resp, err := client.Do(req.WithContext(c))
+	// Upstream request lifetime is tied to the client request.
+	// c only bounds the time until response headers are received,
+	// cancelling it after that would cut off streamed bodies
+	// and close the upstream connection before it can be reused.
+	ctx, cancel := context.WithCancel(r.Context())
+	stop := context.AfterFunc(c, cancel)
+
+	resp, err := ht.client.Do(req.WithContext(ctx))
+	stop()
 	if err != nil {
+		cancel()
 		return nil, errors.New(
 			http.StatusBadGateway,
 			http.StatusText(http.StatusBadGateway),
@@ -87,30 +109,24 @@ func (ht *HTTP) proxyPass(c context.Context, uri string, r *http.Request) (*http
 		)
 	}
 
-	if resp.StatusCode >= 500 && resp.StatusCode < 600 {
-		return nil, errors.New(
-			resp.StatusCode,
-			resp.Status,
-			"upstream server unavailable",
-		)
-	}
+	resp.Body = wrapBody(resp.Body, cancel)
 
 	return resp, nil
 }
 
 func (ht *HTTP) wrapRequest(uri string, r *http.Request) (*http.Request, error) {
-	defer r.Body.Close()
-
-	// TODO - detect protocol from location http_pass xxx_pass
-	uuri := "http://" + strings.TrimRight(uri, "/") + r.URL.Path
+	uuri := ht.config.scheme + "://" + strings.TrimRight(uri, "/") + r.URL.Path
 	if r.URL.RawQuery != "" {
 		uuri += "?" + r.URL.RawQuery
 	}
 
+	// client request body is streamed to upstream
+	// and closed by the server once request is handled
 	req, err := http.NewRequest(r.Method, uuri, r.Body)
 	if err != nil {
 		return nil, err
 	}
+	req.ContentLength = r.ContentLength
 
 	for h, v := range r.Header {
 		if v != nil && len(v) > 0 && v[0] != "" {
@@ -118,15 +134,96 @@ func (ht *HTTP) wrapRequest(uri string, r *http.Request) (*http.Request, error)
 		}
 	}
 
+	proxy.RemoveHopHeaders(req.Header)
+
+	// TE: trailers is passed on as it is required by grpc
+	if te := r.Header.Get("Te"); strings.EqualFold(strings.TrimSpace(te), "trailers") {
+		req.Header.Set("Te", "trailers")
+	}
+
 	if ht.config.passHeaders != nil {
 		for h, v := range ht.config.passHeaders {
 			req.Header.Add(h, v)
 		}
 	}
 
-	req.Header.Add("Connection", "Close")
+	// upgraded connection (eg. websocket) is
+	// returned by the client as response body
+	if up := proxy.UpgradeType(r.Header); up != "" {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", up)
+	}
+
 	req.Header.Add("X-Real-IP", r.RemoteAddr)
 	req.Header.Add("X-Forwarded-Host", r.Host)
 
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	req.Header.Set("X-Forwarded-Proto", proto)
+
+	if ht.config.clientCertHeaders != nil {
+		ht.config.clientCertHeaders.set(req, r)
+	}
+
 	return req, nil
 }
+
+// body calls cancel once closed
+type body struct {
+	io.ReadCloser
+	cancel func()
+	once   sync.Once
+}
+
+func (b *body) Close() error {
+	defer b.once.Do(b.cancel)
+	return b.ReadCloser.Close()
+}
+
+// rwBody represents upgraded connection body
+type rwBody struct {
+	*body
+	w io.Writer
+}
+
+func (b *rwBody) Write(p []byte) (int, error) {
+	return b.w.Write(p)
+}
+
+func wrapBody(rc io.ReadCloser, cancel func()) io.ReadCloser {
+	b := &body{ReadCloser: rc, cancel: cancel}
+	if w, ok := rc.(io.Writer); ok {
+		return &rwBody{body: b, w: w}
+	}
+	return b
+}
+
+func hashKeyFunc(spec string) func(*http.Request) string {
+	switch {
+	case strings.HasPrefix(spec, "header:"):
+		name := strings.TrimPrefix(spec, "header:")
+		return func(r *http.Request) string {
+			return r.Header.Get(name)
+		}
+	case strings.HasPrefix(spec, "cookie:"):
+		name := strings.TrimPrefix(spec, "cookie:")
+		return func(r *http.Request) string {
+			c, err := r.Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return c.Value
+		}
+	}
+	return clientIP
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
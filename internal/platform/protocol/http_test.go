@@ -3,10 +3,15 @@ package protocol_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -15,6 +20,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/errors"
 	"github.com/tonto/gourmet/internal/platform/protocol"
 	"github.com/tonto/gourmet/internal/upstream"
 )
@@ -41,10 +47,12 @@ func TestHTTPServeRequest(t *testing.T) {
 		reqMtd        string
 		reqBody       []byte
 		headers       map[string]string
+		tls           *tls.ConnectionState
 		wantHeaders   map[string]string
 		customHeaders map[string]string
 		assert        func(*testing.T, epreq)
 		assertResp    func()
+		wantStatus    int
 		wantErr       bool
 	}{
 		"test automatic headers": {
@@ -54,8 +62,9 @@ func TestHTTPServeRequest(t *testing.T) {
 			assert: func(t *testing.T, req epreq) {
 				r := req.r
 				assert.Equal(t, "/headers", r.URL.Path)
-				assert.Equal(t, "Close", r.Header.Get("Connection"))
+				assert.Equal(t, "", r.Header.Get("Connection"))
 				assert.Equal(t, "localhost:8080", r.Header.Get("X-Forwarded-Host"))
+				assert.Equal(t, "http", r.Header.Get("X-Forwarded-Proto"))
 				assert.Equal(t, true, testIP("127.0.0.1", r.Header.Get("X-Real-IP")))
 				assert.Equal(t, http.NoBody, r.Body)
 			},
@@ -73,6 +82,27 @@ func TestHTTPServeRequest(t *testing.T) {
 				"X-Some-Header": "1024",
 			},
 		},
+		"test hop headers": {
+			bl:     &mockbl{RW: &rw{}},
+			reqMtd: "GET",
+			reqURL: "/headers",
+			headers: map[string]string{
+				"Connection":          "keep-alive, X-Hop",
+				"X-Hop":               "1",
+				"Keep-Alive":          "timeout=5",
+				"Proxy-Authorization": "Basic Zm9vOmJhcg==",
+				"Te":                  "trailers",
+				"X-Some-Header":       "1024",
+			},
+			wantHeaders: map[string]string{
+				"Connection":          "",
+				"X-Hop":               "",
+				"Keep-Alive":          "",
+				"Proxy-Authorization": "",
+				"Te":                  "trailers",
+				"X-Some-Header":       "1024",
+			},
+		},
 		"test headers pass": {
 			bl:     &mockbl{RW: &rw{}},
 			reqMtd: "POST",
@@ -88,6 +118,49 @@ func TestHTTPServeRequest(t *testing.T) {
 				"X-Some-Header": "1024",
 			},
 		},
+		"test client cert headers": {
+			bl:     &mockbl{RW: &rw{}},
+			reqMtd: "GET",
+			reqURL: "/headers",
+			opts: []protocol.HTTPOption{
+				protocol.WithHTTPClientCertHeaders(protocol.ClientCertHeaders{
+					Subject:     "X-Client-Subject",
+					SANs:        "X-Client-SANs",
+					Fingerprint: "X-Client-Fingerprint",
+				}),
+			},
+			tls: &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{
+					Raw:            []byte("client cert"),
+					Subject:        pkix.Name{CommonName: "admin", Organization: []string{"Foo"}},
+					DNSNames:       []string{"admin.foo.com"},
+					EmailAddresses: []string{"admin@foo.com"},
+				}}},
+			},
+			wantHeaders: map[string]string{
+				"X-Client-Subject":     "CN=admin,O=Foo",
+				"X-Client-SANs":        "admin.foo.com,admin@foo.com",
+				"X-Client-Fingerprint": fmt.Sprintf("%x", sha256.Sum256([]byte("client cert"))),
+				"X-Forwarded-Proto":    "https",
+			},
+		},
+		"test client cert headers not spoofed": {
+			bl:     &mockbl{RW: &rw{}},
+			reqMtd: "GET",
+			reqURL: "/headers",
+			opts: []protocol.HTTPOption{
+				protocol.WithHTTPClientCertHeaders(protocol.ClientCertHeaders{
+					Subject: "X-Client-Subject",
+				}),
+			},
+			headers: map[string]string{
+				"X-Client-Subject": "CN=admin",
+			},
+			tls: &tls.ConnectionState{},
+			wantHeaders: map[string]string{
+				"X-Client-Subject": "",
+			},
+		},
 		"test query params": {
 			bl:     &mockbl{RW: &rw{}},
 			reqMtd: "GET",
@@ -107,11 +180,11 @@ func TestHTTPServeRequest(t *testing.T) {
 			},
 		},
 		"test svc unavailable": {
-			bl:      &mockbl{RW: &rw{}},
-			reqMtd:  "POST",
-			reqURL:  "/unavailable",
-			reqBody: []byte("test body"),
-			wantErr: true,
+			bl:         &mockbl{RW: &rw{}},
+			reqMtd:     "POST",
+			reqURL:     "/unavailable",
+			reqBody:    []byte("test body"),
+			wantStatus: http.StatusServiceUnavailable,
 		},
 		"test upstreams unavailable": {
 			bl:      &mockbl{RW: &rw{}, Err: true},
@@ -153,6 +226,7 @@ func TestHTTPServeRequest(t *testing.T) {
 			r := httptest.NewRequest(c.reqMtd, balancerPath+c.reqURL, body)
 			r.Header.Set("X-Test-Name", name)
 			r.RemoteAddr = "127.0.0.1"
+			r.TLS = c.tls
 
 			if c.headers != nil {
 				for h, v := range c.headers {
@@ -160,7 +234,7 @@ func TestHTTPServeRequest(t *testing.T) {
 				}
 			}
 
-			_, err := h.ServeRequest(r)
+			resp, err := h.ServeRequest(r)
 
 			if c.wantErr != (err != nil) {
 				t.Fatalf("error should be %v got: %v", c.wantErr, err)
@@ -169,6 +243,12 @@ func TestHTTPServeRequest(t *testing.T) {
 			if c.wantErr && (err != nil) {
 				return
 			}
+			defer resp.Body.Close()
+
+			if c.wantStatus != 0 {
+				assert.Equal(t, c.wantStatus, resp.StatusCode)
+				assert.Equal(t, 1, c.bl.Next.Fails())
+			}
 
 			m.Lock()
 			req := <-chans[name]
@@ -187,6 +267,357 @@ func TestHTTPServeRequest(t *testing.T) {
 	}
 }
 
+func TestHTTPHashKey(t *testing.T) {
+	cases := map[string]struct {
+		spec    string
+		req     func(*http.Request)
+		wantKey string
+	}{
+		"ip": {
+			spec:    "ip",
+			wantKey: "10.0.0.1",
+		},
+		"header": {
+			spec: "header:X-Tenant",
+			req: func(r *http.Request) {
+				r.Header.Set("X-Tenant", "tenant-a")
+			},
+			wantKey: "tenant-a",
+		},
+		"missing header": {
+			spec: "header:X-Tenant",
+		},
+		"cookie": {
+			spec: "cookie:session",
+			req: func(r *http.Request) {
+				r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+			},
+			wantKey: "abc",
+		},
+		"missing cookie": {
+			spec: "cookie:session",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			bl := &mockbl{Err: true}
+			h := protocol.NewHTTP(bl, protocol.WithHTTPHashKey(c.spec))
+
+			r := httptest.NewRequest("GET", balancerPath+"/", nil)
+			r.RemoteAddr = "10.0.0.1:51234"
+			if c.req != nil {
+				c.req(r)
+			}
+
+			h.ServeRequest(r)
+			assert.Equal(t, c.wantKey, bl.Key)
+		})
+	}
+}
+
+func TestHTTPQueue(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ok")
+	}))
+	defer ts.Close()
+
+	cases := map[string]struct {
+		opts      []protocol.HTTPOption
+		servers   func() []*upstream.Server
+		wantErr   bool
+		wantCalls int
+	}{
+		"queue full": {
+			servers: func() []*upstream.Server {
+				return []*upstream.Server{fullServer(), fullServer()}
+			},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		"next upstream": {
+			opts: []protocol.HTTPOption{protocol.WithHTTPNextUpstream(2)},
+			servers: func() []*upstream.Server {
+				return []*upstream.Server{fullServer(), runServer(ts.URL)}
+			},
+			wantCalls: 2,
+		},
+		"next upstream all full": {
+			opts: []protocol.HTTPOption{protocol.WithHTTPNextUpstream(3)},
+			servers: func() []*upstream.Server {
+				return []*upstream.Server{fullServer(), fullServer(), fullServer()}
+			},
+			wantErr:   true,
+			wantCalls: 3,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			bl := &seqbl{servers: c.servers()}
+			h := protocol.NewHTTP(bl, c.opts...)
+
+			r := httptest.NewRequest("GET", balancerPath+"/", nil)
+			resp, err := h.ServeRequest(r)
+
+			assert.Equal(t, c.wantCalls, bl.calls)
+
+			if !c.wantErr {
+				assert.NoError(t, err)
+				b, _ := ioutil.ReadAll(resp.Body)
+				assert.Equal(t, "ok", string(b))
+				return
+			}
+
+			gerr, ok := err.(*errors.Error)
+			if !ok {
+				t.Fatalf("expected gourmet error got: %v", err)
+			}
+			assert.Equal(t, http.StatusServiceUnavailable, gerr.Status)
+			assert.Equal(t, "1", gerr.Header.Get("Retry-After"))
+		})
+	}
+}
+
+func TestHTTPKeepAlive(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.RemoteAddr)
+	}))
+	defer ts.Close()
+
+	bl := &seqbl{servers: []*upstream.Server{runServer(ts.URL)}}
+	h := protocol.NewHTTP(bl, protocol.WithHTTPTransport(&http.Transport{}))
+
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		resp, err := h.ServeRequest(httptest.NewRequest("GET", balancerPath+"/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		addrs = append(addrs, string(b))
+	}
+
+	assert.Equal(t, addrs[0], addrs[1])
+	assert.Equal(t, addrs[0], addrs[2])
+}
+
+func TestHTTPRedirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+	}))
+	defer ts.Close()
+
+	bl := &seqbl{servers: []*upstream.Server{runServer(ts.URL)}}
+	h := protocol.NewHTTP(bl)
+
+	resp, err := h.ServeRequest(httptest.NewRequest("GET", balancerPath+"/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "/moved", resp.Header.Get("Location"))
+}
+
+func TestHTTPS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.URL.Path, r.TLS.ServerName)
+	}))
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "example.com"},
+	}
+
+	bl := &seqbl{servers: []*upstream.Server{runServer(strings.TrimPrefix(ts.URL, "https://"))}}
+	h := protocol.NewHTTP(bl, protocol.WithHTTPScheme("https"), protocol.WithHTTPTransport(transport))
+
+	resp, err := h.ServeRequest(httptest.NewRequest("GET", balancerPath+"/foo", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "/foo example.com", string(body))
+}
+
+func TestHTTPSlowUpstream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	// upstream response headers take longer than fail timeout
+	s := upstream.NewServer(
+		strings.TrimPrefix(ts.URL, "http://"),
+		upstream.WithFailTimeout(50*time.Millisecond),
+		upstream.WithMaxFail(1),
+		upstream.WithQueueSize(1),
+	)
+	c := make(chan struct{})
+	go s.Run(c)
+	defer func() { c <- struct{}{} }()
+
+	h := protocol.NewHTTP(&seqbl{servers: []*upstream.Server{s}})
+
+	resp, err := h.ServeRequest(httptest.NewRequest("GET", balancerPath+"/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(b))
+	assert.Equal(t, 0, s.Fails())
+	assert.True(t, s.Available())
+}
+
+func TestHTTPStreaming(t *testing.T) {
+	cancelled := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: foo\n\n")
+		w.(http.Flusher).Flush()
+
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, "data: bar\n\n")
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer ts.Close()
+
+	// fail timeout does not bound streamed responses
+	s := upstream.NewServer(
+		strings.TrimPrefix(ts.URL, "http://"),
+		upstream.WithFailTimeout(50*time.Millisecond),
+		upstream.WithQueueSize(1),
+	)
+	c := make(chan struct{})
+	go s.Run(c)
+
+	h := protocol.NewHTTP(&seqbl{servers: []*upstream.Server{s}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", balancerPath+"/", nil).WithContext(ctx)
+
+	resp, err := h.ServeRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := make([]byte, 22)
+	_, err = io.ReadFull(resp.Body, p)
+	assert.Nil(t, err)
+	assert.Equal(t, "data: foo\n\ndata: bar\n\n", string(p))
+	assert.Equal(t, 1, s.Active())
+
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream request not cancelled with client request")
+	}
+
+	resp.Body.Close()
+	assert.Equal(t, 0, s.Active())
+}
+
+func TestHTTPUpgrade(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Upgrade", r.Header.Get("Connection"))
+		assert.Equal(t, "websocket", r.Header.Get("Upgrade"))
+
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		fmt.Fprint(brw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	}))
+	defer ts.Close()
+
+	s := runServer(ts.URL)
+	h := protocol.NewHTTP(&seqbl{servers: []*upstream.Server{s}})
+
+	r := httptest.NewRequest("GET", balancerPath+"/ws", nil)
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+
+	resp, err := h.ServeRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, 1, s.Active())
+
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatal("upgraded response body is not writable")
+	}
+
+	fmt.Fprint(rwc, "ping")
+	p := make([]byte, 4)
+	_, err = io.ReadFull(rwc, p)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(p))
+
+	rwc.Close()
+	assert.Equal(t, 0, s.Active())
+}
+
+func BenchmarkHTTPServeRequest(b *testing.B) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "response body")
+	}))
+	defer ts.Close()
+
+	cases := map[string]http.RoundTripper{
+		// previous behaviour, a new connection per request
+		"no keep-alive": &http.Transport{DisableKeepAlives: true},
+		"pooled": &http.Transport{
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+
+	for name, rt := range cases {
+		b.Run(name, func(b *testing.B) {
+			bl := &seqbl{servers: []*upstream.Server{runServer(ts.URL)}}
+			h := protocol.NewHTTP(bl, protocol.WithHTTPTransport(rt))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r := httptest.NewRequest("GET", balancerPath+"/", nil)
+				resp, err := h.ServeRequest(r)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
 func testIP(expected, ip string) bool {
 	log.Println(ip)
 	ips := strings.Split(ip, ":")
@@ -233,7 +664,11 @@ func spinUpUpstreams() func() {
 	})
 
 	// TODO - Add more endpoints with responses
-	go func() { s.ListenAndServe() }()
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() { s.Serve(l) }()
 	return func() {
 		s.Shutdown(context.Background())
 	}
@@ -253,9 +688,11 @@ type mockbl struct {
 	Next *upstream.Server
 	RW   http.ResponseWriter
 	Err  bool
+	Key  string
 }
 
-func (m *mockbl) NextServer() (*upstream.Server, error) {
+func (m *mockbl) NextServer(key string) (*upstream.Server, error) {
+	m.Key = key
 	if m.Err {
 		return nil, fmt.Errorf("upstream unavailable")
 	}
@@ -277,6 +714,37 @@ func newServer() *upstream.Server {
 	return s
 }
 
+type seqbl struct {
+	servers []*upstream.Server
+	calls   int
+}
+
+func (b *seqbl) NextServer(string) (*upstream.Server, error) {
+	s := b.servers[b.calls%len(b.servers)]
+	b.calls++
+	return s, nil
+}
+
+// fullServer returns a server which is not running
+// and has no queue capacity
+func fullServer() *upstream.Server {
+	return upstream.NewServer("localhost:1")
+}
+
+func runServer(uri string) *upstream.Server {
+	s := upstream.NewServer(
+		strings.TrimPrefix(uri, "http://"),
+		upstream.WithFailTimeout(time.Second),
+		upstream.WithMaxFail(10),
+		upstream.WithQueueSize(1),
+	)
+
+	c := make(chan struct{})
+	go func() { s.Run(c) }()
+
+	return s
+}
+
 type rw struct{}
 
 func (r *rw) Header() http.Header {
@@ -1,6 +1,12 @@
 package protocol
 
-import "time"
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
 
 // HTTPOption represents http protocol config option
 type HTTPOption func(*Config)
@@ -12,9 +18,131 @@ func WithHTTPHeaders(h map[string]string) HTTPOption {
 	}
 }
 
-// WithHTTPRequestTimeout sets a timeout for every upstream request
+// WithHTTPRequestTimeout sets a timeout for receiving response
+// headers of every upstream request. Response body is not
+// limited so streamed responses are not interrupted.
 func WithHTTPRequestTimeout(d time.Duration) HTTPOption {
 	return func(cfg *Config) {
 		cfg.requestTimeout = d
 	}
 }
+
+// WithHTTPHashKey sets the request key used by hashing balancers
+// spec can be one of "ip", "header:<name>" or "cookie:<name>"
+func WithHTTPHashKey(spec string) HTTPOption {
+	return func(cfg *Config) {
+		cfg.hashKey = hashKeyFunc(spec)
+	}
+}
+
+// WithHTTPNextUpstream enables passing a request to next
+// server selected by the balancer when a server queue is full
+// or the request times out waiting in it. tries limits the
+// number of servers tried.
+func WithHTTPNextUpstream(tries int) HTTPOption {
+	return func(cfg *Config) {
+		cfg.nextUpstream = tries
+	}
+}
+
+// WithHTTPTransport sets round tripper used for upstream requests
+// It should be shared by all locations passing to the same upstream
+// so that connections to upstream servers are reused.
+// http.DefaultTransport is used by default.
+func WithHTTPTransport(rt http.RoundTripper) HTTPOption {
+	return func(cfg *Config) {
+		cfg.transport = rt
+	}
+}
+
+// WithHTTPScheme sets upstream request url scheme, http or https
+// Transport set by WithHTTPTransport has to be configured
+// for tls if https is used.
+func WithHTTPScheme(scheme string) HTTPOption {
+	return func(cfg *Config) {
+		cfg.scheme = scheme
+	}
+}
+
+// WithHTTPClientCertHeaders passes verified tls client
+// certificate details to upstream in request headers h
+func WithHTTPClientCertHeaders(h ClientCertHeaders) HTTPOption {
+	return func(cfg *Config) {
+		cfg.clientCertHeaders = &h
+	}
+}
+
+// FastCGIOption represents fastcgi protocol config option
+type FastCGIOption func(*FastCGIConfig)
+
+// WithFastCGIRoot sets document root used for
+// DOCUMENT_ROOT and SCRIPT_FILENAME params
+func WithFastCGIRoot(root string) FastCGIOption {
+	return func(cfg *FastCGIConfig) {
+		cfg.root = strings.TrimRight(root, "/")
+	}
+}
+
+// WithFastCGIIndex sets script name appended
+// to paths ending with a slash, index.php by default
+func WithFastCGIIndex(index string) FastCGIOption {
+	return func(cfg *FastCGIConfig) {
+		cfg.index = index
+	}
+}
+
+// WithFastCGISplitPath sets regexp with two capture groups
+// splitting request path into SCRIPT_NAME and PATH_INFO
+// eg. `^(.+?\.php)(/.*)$` (default)
+func WithFastCGISplitPath(re *regexp.Regexp) FastCGIOption {
+	return func(cfg *FastCGIConfig) {
+		cfg.splitPath = re
+	}
+}
+
+// WithFastCGIParams sets additional params passed with
+// every request, overriding default CGI params
+func WithFastCGIParams(p map[string]string) FastCGIOption {
+	return func(cfg *FastCGIConfig) {
+		cfg.params = p
+	}
+}
+
+// WithFastCGIStderr sets writer receiving upstream stderr stream
+func WithFastCGIStderr(w io.Writer) FastCGIOption {
+	return func(cfg *FastCGIConfig) {
+		cfg.stderr = w
+	}
+}
+
+// WithFastCGIConnectTimeout sets timeout for
+// connecting to upstream fastcgi server
+func WithFastCGIConnectTimeout(d time.Duration) FastCGIOption {
+	return func(cfg *FastCGIConfig) {
+		cfg.connectTimeout = d
+	}
+}
+
+// WithFastCGIReadTimeout sets a timeout for receiving response
+// headers, response body is not limited
+func WithFastCGIReadTimeout(d time.Duration) FastCGIOption {
+	return func(cfg *FastCGIConfig) {
+		cfg.readTimeout = d
+	}
+}
+
+// WithFastCGIHashKey sets the request key used by hashing balancers
+// spec can be one of "ip", "header:<name>" or "cookie:<name>"
+func WithFastCGIHashKey(spec string) FastCGIOption {
+	return func(cfg *FastCGIConfig) {
+		cfg.hashKey = hashKeyFunc(spec)
+	}
+}
+
+// WithFastCGINextUpstream enables passing a request to next
+// server selected by the balancer when a server queue is full
+func WithFastCGINextUpstream(tries int) FastCGIOption {
+	return func(cfg *FastCGIConfig) {
+		cfg.nextUpstream = tries
+	}
+}
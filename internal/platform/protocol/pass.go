@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/errors"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+// passFunc passes r to upstream server at uri and returns
+// upstream response once its headers have been received
+type passFunc func(c context.Context, uri string, r *http.Request) (*http.Response, error)
+
+// balance serves a request on the server selected by bl, trying
+// up to tries servers if selected server queue is full
+func balance(bl balancer.Balancer, key string, tries int, f func(*upstream.Server) (*http.Response, error)) (*http.Response, error) {
+	if tries < 1 {
+		tries = 1
+	}
+
+	var err error
+
+	for i := 0; i < tries; i++ {
+		var s *upstream.Server
+
+		s, err = bl.NextServer(key)
+		if err != nil {
+			return nil, errors.New(
+				http.StatusServiceUnavailable,
+				http.StatusText(http.StatusServiceUnavailable),
+				err.Error(),
+			)
+		}
+
+		var resp *http.Response
+
+		resp, err = f(s)
+		if err != upstream.ErrQueueFull && err != upstream.ErrQueueTimeout {
+			return resp, err
+		}
+	}
+
+	e := errors.New(
+		http.StatusServiceUnavailable,
+		http.StatusText(http.StatusServiceUnavailable),
+		err.Error(),
+	)
+	e.Header = http.Header{"Retry-After": []string{retryAfter}}
+
+	return nil, e
+}
+
+// serve passes r to s through its request queue
+func serve(s *upstream.Server, r *http.Request, pass passFunc) (*http.Response, error) {
+	var response *http.Response
+
+	// server is released once the response body is closed
+	// so long lived responses are accounted for as active
+	s.Acquire()
+
+	release, err := s.Do(r.Context(), func(c context.Context, uri string) error {
+		resp, err := pass(c, uri, r)
+		if err != nil {
+			return err
+		}
+		response = resp
+		return nil
+	})
+	if err != nil {
+		s.Release()
+		return nil, err
+	}
+
+	// upstream errors are passed on to the client
+	// and accounted for by passive health checks
+	if response.StatusCode >= 500 && response.StatusCode < 600 {
+		s.Fail()
+	}
+
+	response.Body = wrapBody(response.Body, func() {
+		release()
+		s.Release()
+	})
+
+	return response, nil
+}
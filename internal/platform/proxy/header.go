@@ -0,0 +1,50 @@
+// Package proxy provides helpers shared by http and stream proxies
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopHeaders represents hop-by-hop headers (RFC 7230 section 6.1)
+// which are meaningful only for a single connection
+// and must not be forwarded by proxies
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopHeaders removes hop-by-hop headers including
+// the ones listed in Connection header
+func RemoveHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				h.Del(f)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+// UpgradeType returns the protocol a connection is being
+// upgraded to (eg. websocket) or an empty string
+func UpgradeType(h http.Header) string {
+	for _, v := range h["Connection"] {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), "upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
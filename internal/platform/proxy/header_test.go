@@ -0,0 +1,79 @@
+package proxy_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/platform/proxy"
+)
+
+func TestRemoveHopHeaders(t *testing.T) {
+	cases := map[string]struct {
+		header http.Header
+		want   http.Header
+	}{
+		"test hop headers": {
+			header: http.Header{
+				"Connection":        {"keep-alive"},
+				"Keep-Alive":        {"timeout=5"},
+				"Transfer-Encoding": {"chunked"},
+				"Te":                {"trailers"},
+				"Content-Type":      {"text/plain"},
+			},
+			want: http.Header{
+				"Content-Type": {"text/plain"},
+			},
+		},
+		"test connection listed": {
+			header: http.Header{
+				"Connection":   {"X-Foo, X-Bar"},
+				"X-Foo":        {"foo"},
+				"X-Bar":        {"bar"},
+				"X-Baz":        {"baz"},
+				"Content-Type": {"text/plain"},
+			},
+			want: http.Header{
+				"X-Baz":        {"baz"},
+				"Content-Type": {"text/plain"},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			proxy.RemoveHopHeaders(c.header)
+			assert.Equal(t, c.want, c.header)
+		})
+	}
+}
+
+func TestUpgradeType(t *testing.T) {
+	cases := map[string]struct {
+		header http.Header
+		want   string
+	}{
+		"test websocket": {
+			header: http.Header{
+				"Connection": {"keep-alive, Upgrade"},
+				"Upgrade":    {"websocket"},
+			},
+			want: "websocket",
+		},
+		"test upgrade not in connection": {
+			header: http.Header{
+				"Connection": {"keep-alive"},
+				"Upgrade":    {"websocket"},
+			},
+		},
+		"test no upgrade": {
+			header: http.Header{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, c.want, proxy.UpgradeType(c.header))
+		})
+	}
+}
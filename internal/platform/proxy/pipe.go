@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const bufferSize = 32 * 1024
+
+// Pipe copies data between client and up in both directions
+// until either side closes or, if idle is positive, no data is
+// transferred for idle time. It returns the number of bytes
+// sent to the client and received from it.
+func Pipe(client, up io.ReadWriteCloser, idle time.Duration) (int64, int64) {
+	var sent, received int64
+
+	closeBoth := func() {
+		client.Close()
+		up.Close()
+	}
+
+	extend := func() {}
+	if idle > 0 {
+		t := newIdleTimer(idle, closeBoth)
+		defer t.stop()
+		extend = t.reset
+	}
+
+	errc := make(chan error, 2)
+	go func() { errc <- copyConn(up, client, &received, extend) }()
+	go func() { errc <- copyConn(client, up, &sent, extend) }()
+
+	// closing either side makes the other copy fail
+	// so both are closed as soon as one of them ends
+	<-errc
+	closeBoth()
+	<-errc
+
+	return atomic.LoadInt64(&sent), atomic.LoadInt64(&received)
+}
+
+func copyConn(dst io.Writer, src io.Reader, n *int64, extend func()) error {
+	buf := make([]byte, bufferSize)
+	for {
+		nr, err := src.Read(buf)
+		if nr > 0 {
+			extend()
+			nw, werr := dst.Write(buf[:nr])
+			atomic.AddInt64(n, int64(nw))
+			if werr != nil {
+				return werr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type idleTimer struct {
+	d time.Duration
+	t *time.Timer
+	m sync.Mutex
+}
+
+func newIdleTimer(d time.Duration, f func()) *idleTimer {
+	return &idleTimer{d: d, t: time.AfterFunc(d, f)}
+}
+
+func (it *idleTimer) reset() {
+	it.m.Lock()
+	defer it.m.Unlock()
+	it.t.Reset(it.d)
+}
+
+func (it *idleTimer) stop() {
+	it.m.Lock()
+	defer it.m.Unlock()
+	it.t.Stop()
+}
@@ -0,0 +1,66 @@
+package proxy_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/platform/proxy"
+)
+
+func TestPipe(t *testing.T) {
+	cases := map[string]struct {
+		idle time.Duration
+		peer func(client, up net.Conn)
+		sent int64
+		recv int64
+	}{
+		"test client closes": {
+			peer: func(client, up net.Conn) {
+				go io.Copy(up, up)
+				client.Write([]byte("ping"))
+				io.ReadFull(client, make([]byte, 4))
+				client.Close()
+			},
+			sent: 4,
+			recv: 4,
+		},
+		"test idle timeout": {
+			idle: 50 * time.Millisecond,
+			peer: func(client, up net.Conn) {
+				client.Write([]byte("ping"))
+				io.ReadFull(up, make([]byte, 4))
+			},
+			recv: 4,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			client, clientPeer := net.Pipe()
+			up, upPeer := net.Pipe()
+			defer clientPeer.Close()
+			defer upPeer.Close()
+
+			go c.peer(clientPeer, upPeer)
+
+			done := make(chan struct{})
+			var sent, recv int64
+			go func() {
+				sent, recv = proxy.Pipe(client, up, c.idle)
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("pipe did not return")
+			}
+
+			assert.Equal(t, c.sent, sent)
+			assert.Equal(t, c.recv, recv)
+		})
+	}
+}
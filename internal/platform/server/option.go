@@ -0,0 +1,24 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+)
+
+// Option represents server option
+type Option func(*Server)
+
+// WithLogger sets server error logger
+func WithLogger(l *log.Logger) Option {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// WithTLS enables tls on server listeners
+// Config has to provide server certificates.
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
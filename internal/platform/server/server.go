@@ -0,0 +1,98 @@
+// Package server provides gourmet http server lifecycle
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const shutdownTimeout = 30 * time.Second
+
+// New creates new http server instance
+//
+// Server has no read and write timeouts so that
+// streamed responses (eg. SSE) are not cut off.
+// Besides HTTP/1 it accepts cleartext HTTP/2 (h2c)
+// with prior knowledge as used by grpc clients,
+// or HTTP/2 over tls if tls is enabled.
+func New(h http.Handler, opts ...Option) *Server {
+	var p http.Protocols
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(true)
+
+	srv := Server{
+		httpServer: &http.Server{
+			Handler:           h,
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			Protocols:         &p,
+		},
+	}
+
+	for _, o := range opts {
+		o(&srv)
+	}
+
+	if srv.logger == nil {
+		srv.logger = log.New(os.Stdout, "http ", log.Ldate|log.Ltime)
+	}
+
+	srv.httpServer.ErrorLog = srv.logger
+	srv.httpServer.TLSConfig = srv.tlsConfig
+
+	return &srv
+}
+
+// Server represents http server
+type Server struct {
+	httpServer *http.Server
+	logger     *log.Logger
+	tlsConfig  *tls.Config
+}
+
+// Serve accepts connections on l until the server is stopped
+func (s *Server) Serve(l net.Listener) error {
+	s.logger.Printf("Starting server at: %s", l.Addr())
+
+	var err error
+	if s.tlsConfig != nil {
+		// certificates are provided by tls config
+		err = s.httpServer.ServeTLS(l, "", "")
+	} else {
+		err = s.httpServer.Serve(l)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+// Stop attempts to gracefully shutdown the server
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return s.httpServer.Shutdown(ctx)
+}
+
+// RedirectHandler returns handler redirecting
+// all requests to https server on port
+func RedirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,171 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/platform/server"
+)
+
+func TestServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server.New(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "ok")
+		}),
+		server.WithLogger(log.New(ioutil.Discard, "", 0)),
+	)
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(l) }()
+
+	resp, err := http.Get("http://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, "ok", string(b))
+
+	assert.Nil(t, srv.Stop())
+
+	select {
+	case err := <-errc:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("server not stopped")
+	}
+}
+
+func TestServerH2C(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server.New(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.Proto)
+		}),
+		server.WithLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	var p http.Protocols
+	p.SetUnencryptedHTTP2(true)
+	client := http.Client{Transport: &http.Transport{Protocols: &p}}
+
+	resp, err := client.Get("http://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, "HTTP/2.0", string(b))
+}
+
+func TestServerTLS(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server.New(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.Proto, " ", r.TLS != nil)
+		}),
+		server.WithLogger(log.New(ioutil.Discard, "", 0)),
+		server.WithTLS(&tls.Config{Certificates: []tls.Certificate{selfSigned(t, "foo.com")}}),
+	)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	client := http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+
+	resp, err := client.Get("https://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, "HTTP/2.0 true", string(b))
+}
+
+func TestRedirectHandler(t *testing.T) {
+	cases := map[string]struct {
+		port   int
+		target string
+		want   string
+	}{
+		"default port": {
+			port:   443,
+			target: "http://foo.com:80/bar?baz=1",
+			want:   "https://foo.com/bar?baz=1",
+		},
+		"custom port": {
+			port:   8443,
+			target: "http://foo.com/bar",
+			want:   "https://foo.com:8443/bar",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.RedirectHandler(c.port).ServeHTTP(w, httptest.NewRequest("GET", c.target, nil))
+
+			assert.Equal(t, http.StatusMovedPermanently, w.Code)
+			assert.Equal(t, c.want, w.Header().Get("Location"))
+		})
+	}
+}
+
+// selfSigned creates self signed certificate valid for names
+func selfSigned(t *testing.T, names ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, _ := x509.ParseCertificate(der)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
@@ -0,0 +1,76 @@
+package stream
+
+import (
+	"log"
+	"time"
+)
+
+// TCPOption represents tcp stream proxy option
+type TCPOption func(*TCPConfig)
+
+// WithTCPConnectTimeout sets upstream connect timeout
+func WithTCPConnectTimeout(d time.Duration) TCPOption {
+	return func(cfg *TCPConfig) {
+		cfg.connectTimeout = d
+	}
+}
+
+// WithTCPIdleTimeout sets the time after which connections
+// with no traffic in either direction are closed
+func WithTCPIdleTimeout(d time.Duration) TCPOption {
+	return func(cfg *TCPConfig) {
+		cfg.idleTimeout = d
+	}
+}
+
+// WithTCPNextUpstream enables passing connections to the next
+// server if the selected server queue is full, up to tries times
+func WithTCPNextUpstream(tries int) TCPOption {
+	return func(cfg *TCPConfig) {
+		cfg.nextUpstream = tries
+	}
+}
+
+// WithTCPTLSRoutes enables tls passthrough, client hello of each
+// connection is peeked without terminating tls and the connection
+// is passed to the first route matching its server name and alpn.
+// Connections not matching any route are passed to the proxy
+// balancer or closed if it is nil.
+func WithTCPTLSRoutes(routes ...Route) TCPOption {
+	return func(cfg *TCPConfig) {
+		cfg.routes = routes
+	}
+}
+
+// WithTCPHelloTimeout sets how long tls passthrough waits
+// for client hello, default is 5 seconds
+func WithTCPHelloTimeout(d time.Duration) TCPOption {
+	return func(cfg *TCPConfig) {
+		cfg.helloTimeout = d
+	}
+}
+
+// WithTCPLogger sets stream proxy logger
+func WithTCPLogger(l *log.Logger) TCPOption {
+	return func(cfg *TCPConfig) {
+		cfg.logger = l
+	}
+}
+
+// UDPOption represents udp stream proxy option
+type UDPOption func(*UDPConfig)
+
+// WithUDPIdleTimeout sets the time after which client
+// sessions with no datagrams sent are expired
+func WithUDPIdleTimeout(d time.Duration) UDPOption {
+	return func(cfg *UDPConfig) {
+		cfg.idleTimeout = d
+	}
+}
+
+// WithUDPLogger sets stream proxy logger
+func WithUDPLogger(l *log.Logger) UDPOption {
+	return func(cfg *UDPConfig) {
+		cfg.logger = l
+	}
+}
@@ -0,0 +1,145 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/tonto/gourmet/internal/balancer"
+)
+
+const defaultHelloTimeout = 5 * time.Second
+
+// errHelloRead stops the handshake once client hello is read
+var errHelloRead = errors.New("client hello read")
+
+// Route represents tls passthrough route
+// Connections whose client hello matches the route
+// are passed to upstream servers selected by Balancer.
+type Route struct {
+	// ServerName is exact host name or a wildcard
+	// such as *.foo.com matching any of its subdomains
+	ServerName string
+
+	// ALPN optionally restricts the route to clients
+	// offering any of the listed protocols
+	ALPN []string
+
+	Balancer balancer.Balancer
+}
+
+func (r *Route) match(hello *tls.ClientHelloInfo) bool {
+	if !matchServerName(r.ServerName, hello.ServerName) {
+		return false
+	}
+
+	if len(r.ALPN) == 0 {
+		return true
+	}
+
+	for _, want := range r.ALPN {
+		for _, p := range hello.SupportedProtos {
+			if want == p {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func matchServerName(pattern, name string) bool {
+	pattern = strings.ToLower(pattern)
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+
+	if name == "" {
+		return false
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(name, pattern[1:])
+	}
+
+	return pattern == name
+}
+
+// route returns balancer of the first route matching conn
+// client hello, or the default one if none matches. Returned
+// conn replays peeked bytes before reading from the client.
+func (p *TCP) route(conn net.Conn) (net.Conn, balancer.Balancer, string) {
+	if len(p.config.routes) == 0 {
+		return conn, p.balancer, ""
+	}
+
+	timeout := p.config.helloTimeout
+	if timeout <= 0 {
+		timeout = defaultHelloTimeout
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	hello, peeked := peekClientHello(conn)
+	conn.SetReadDeadline(time.Time{})
+
+	conn = &peekedConn{Conn: conn, r: io.MultiReader(peeked, conn)}
+
+	if hello == nil {
+		return conn, p.balancer, ""
+	}
+
+	for i := range p.config.routes {
+		if p.config.routes[i].match(hello) {
+			return conn, p.config.routes[i].Balancer, hello.ServerName
+		}
+	}
+
+	return conn, p.balancer, hello.ServerName
+}
+
+// peekClientHello reads tls client hello from r returning
+// it along with the bytes read. Returned hello is nil if
+// the client did not start a valid tls handshake.
+func peekClientHello(r io.Reader) (*tls.ClientHelloInfo, *bytes.Buffer) {
+	var hello *tls.ClientHelloInfo
+
+	peeked := new(bytes.Buffer)
+
+	tls.Server(readOnlyConn{r: io.TeeReader(r, peeked)}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = &tls.ClientHelloInfo{
+				ServerName:      h.ServerName,
+				SupportedProtos: append([]string(nil), h.SupportedProtos...),
+			}
+			return nil, errHelloRead
+		},
+	}).Handshake()
+
+	return hello, peeked
+}
+
+// readOnlyConn lets tls server read client
+// hello without writing anything back
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// peekedConn reads from r which replays peeked
+// bytes before reading from the underlying conn
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
@@ -0,0 +1,183 @@
+package stream_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/platform/stream"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+func TestTCPTLSRoutes(t *testing.T) {
+	cases := map[string]struct {
+		serverName string
+		alpn       []string
+		noDefault  bool
+		want       string
+	}{
+		"exact name": {
+			serverName: "foo.com",
+			want:       "foo",
+		},
+		"exact name case insensitive": {
+			serverName: "FOO.com",
+			want:       "foo",
+		},
+		"wildcard": {
+			serverName: "a.b.bar.com",
+			want:       "bar",
+		},
+		"wildcard does not match parent": {
+			serverName: "bar.com",
+			want:       "default",
+		},
+		"alpn": {
+			serverName: "foo.com",
+			alpn:       []string{"h2"},
+			want:       "foo-h2",
+		},
+		"no match": {
+			serverName: "baz.com",
+			want:       "default",
+		},
+		"no server name": {
+			want: "default",
+		},
+		"no default": {
+			serverName: "baz.com",
+			noDefault:  true,
+		},
+	}
+
+	cert := selfSigned(t)
+
+	ups := map[string]*upstream.Server{}
+	for _, name := range []string{"foo", "foo-h2", "bar", "default"} {
+		l := tlsServer(t, name, cert)
+		defer l.Close()
+		ups[name] = runServer(l.Addr().String())
+	}
+
+	rr := func(name string) balancer.Balancer {
+		return balancer.NewRoundRobin([]*upstream.Server{ups[name]})
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var def balancer.Balancer
+			if !c.noDefault {
+				def = rr("default")
+			}
+
+			p := stream.NewTCP(
+				def,
+				stream.WithTCPTLSRoutes(
+					stream.Route{ServerName: "foo.com", ALPN: []string{"h2"}, Balancer: rr("foo-h2")},
+					stream.Route{ServerName: "foo.com", Balancer: rr("foo")},
+					stream.Route{ServerName: "*.bar.com", Balancer: rr("bar")},
+				),
+				logger(),
+			)
+
+			conn := serve(t, p)
+			defer p.Stop()
+			defer conn.Close()
+
+			tc := tls.Client(conn, &tls.Config{
+				ServerName:         c.serverName,
+				NextProtos:         c.alpn,
+				InsecureSkipVerify: true,
+			})
+			tc.SetDeadline(time.Now().Add(time.Second))
+
+			line, err := bufio.NewReader(tc).ReadString('\n')
+			if c.want == "" {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, c.want+"\n", line)
+		})
+	}
+}
+
+func TestTCPTLSRoutesNotTLS(t *testing.T) {
+	ul := echoServer(t)
+	defer ul.Close()
+
+	p := stream.NewTCP(
+		balancer.NewRoundRobin([]*upstream.Server{runServer(ul.Addr().String())}),
+		stream.WithTCPTLSRoutes(stream.Route{ServerName: "foo.com"}),
+		stream.WithTCPHelloTimeout(50*time.Millisecond),
+		logger(),
+	)
+
+	conn := serve(t, p)
+	defer p.Stop()
+	defer conn.Close()
+
+	fmt.Fprint(conn, "foo\n")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "foo\n", line)
+}
+
+// tlsServer accepts tls connections writing back name
+func tlsServer(t *testing.T, name string, cert tls.Certificate) net.Listener {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				fmt.Fprintln(conn, name)
+			}()
+		}
+	}()
+	return l
+}
+
+func selfSigned(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gourmet"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
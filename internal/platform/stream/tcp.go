@@ -0,0 +1,309 @@
+// Package stream provides raw (L4) stream proxies
+package stream
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/platform/proxy"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+const (
+	shutdownTimeout = 30 * time.Second
+
+	// maxPending limits data buffered from
+	// a client while connecting to upstream
+	maxPending = 64 * 1024
+)
+
+// NewTCP creates new TCP stream proxy instance
+func NewTCP(bl balancer.Balancer, opts ...TCPOption) *TCP {
+	p := TCP{
+		balancer: bl,
+		conns:    make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(&p.config)
+	}
+
+	if p.config.logger == nil {
+		p.config.logger = log.New(os.Stdout, "tcp ", log.Ldate|log.Ltime)
+	}
+
+	return &p
+}
+
+// TCP represents tcp stream proxy which passes accepted
+// connections to upstream servers selected by the balancer
+type TCP struct {
+	balancer balancer.Balancer
+	config   TCPConfig
+
+	sent     int64
+	received int64
+
+	m     sync.Mutex
+	l     net.Listener
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+	done  chan struct{}
+}
+
+// TCPConfig represents tcp stream proxy configuration
+type TCPConfig struct {
+	connectTimeout time.Duration
+	idleTimeout    time.Duration
+	nextUpstream   int
+	routes         []Route
+	helloTimeout   time.Duration
+	logger         *log.Logger
+}
+
+// Serve accepts connections on l until the proxy is stopped
+func (p *TCP) Serve(l net.Listener) error {
+	p.m.Lock()
+	select {
+	case <-p.done:
+		p.m.Unlock()
+		return nil
+	default:
+		p.l = l
+	}
+	p.m.Unlock()
+
+	p.config.logger.Printf("Starting tcp stream at: %s", l.Addr())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-p.done:
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		p.track(conn, true)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer p.track(conn, false)
+			p.handle(conn)
+		}()
+	}
+}
+
+// Stop closes the listener and waits for active connections
+// to finish, closing the ones still open after a timeout
+func (p *TCP) Stop() error {
+	p.m.Lock()
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	var err error
+	if p.l != nil {
+		err = p.l.Close()
+	}
+	p.m.Unlock()
+
+	wait := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(wait)
+	}()
+
+	select {
+	case <-wait:
+	case <-time.After(shutdownTimeout):
+		p.m.Lock()
+		for c := range p.conns {
+			c.Close()
+		}
+		p.m.Unlock()
+		<-wait
+	}
+
+	return err
+}
+
+// BytesSent returns the total number of bytes sent to clients
+func (p *TCP) BytesSent() int64 { return atomic.LoadInt64(&p.sent) }
+
+// BytesReceived returns the total number of bytes received from clients
+func (p *TCP) BytesReceived() int64 { return atomic.LoadInt64(&p.received) }
+
+func (p *TCP) track(c net.Conn, add bool) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if add {
+		p.conns[c] = struct{}{}
+		return
+	}
+	delete(p.conns, c)
+}
+
+func (p *TCP) handle(conn net.Conn) {
+	defer conn.Close()
+
+	conn, bl, name := p.route(conn)
+	if bl == nil {
+		p.config.logger.Printf("tcp %s: no route for server name %q", conn.RemoteAddr(), name)
+		return
+	}
+
+	ctx, unwatch := p.watch(conn)
+	up, s, err := p.connect(ctx, conn, bl)
+	conn = unwatch()
+	if err != nil {
+		p.config.logger.Printf("tcp %s: error connecting to upstream: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer s.Release()
+	defer up.Close()
+
+	start := time.Now()
+	sent, received := proxy.Pipe(conn, up, p.config.idleTimeout)
+
+	atomic.AddInt64(&p.sent, sent)
+	atomic.AddInt64(&p.received, received)
+
+	p.config.logger.Printf(
+		"tcp %s -> %s: sent %d bytes, received %d bytes, duration %s",
+		conn.RemoteAddr(), s.URI(), sent, received, time.Since(start),
+	)
+}
+
+// connect dials the server selected by bl, trying
+// next servers if selected server queue is full
+func (p *TCP) connect(ctx context.Context, conn net.Conn, bl balancer.Balancer) (net.Conn, *upstream.Server, error) {
+	tries := 1
+	if p.config.nextUpstream > tries {
+		tries = p.config.nextUpstream
+	}
+
+	key, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	var err error
+
+	for i := 0; i < tries; i++ {
+		var s *upstream.Server
+
+		s, err = bl.NextServer(key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var up net.Conn
+
+		up, err = p.dial(ctx, s)
+		if err == nil {
+			return up, s, nil
+		}
+		if err != upstream.ErrQueueFull && err != upstream.ErrQueueTimeout {
+			return nil, nil, err
+		}
+	}
+
+	return nil, nil, err
+}
+
+// dial connects to s through its request queue so that
+// connection failures are accounted for by passive health checks.
+// Waiting in the queue stops once ctx is done.
+func (p *TCP) dial(ctx context.Context, s *upstream.Server) (net.Conn, error) {
+	var up net.Conn
+
+	s.Acquire()
+
+	// once started dial is bounded by connect timeout only so
+	// that clients leaving meanwhile do not fail the server
+	release, err := s.Do(ctx, func(_ context.Context, uri string) error {
+		d := net.Dialer{Timeout: p.config.connectTimeout}
+		conn, err := d.DialContext(context.Background(), "tcp", uri)
+		if err != nil {
+			return err
+		}
+		up = conn
+		return nil
+	})
+	if err != nil {
+		s.Release()
+		return nil, err
+	}
+
+	return &heldConn{Conn: up, release: release}, nil
+}
+
+// watch returns ctx which is cancelled once client closes conn
+// or the proxy is stopped. Calling unwatch stops watching and
+// returns conn replaying data the client has sent meanwhile.
+func (p *TCP) watch(conn net.Conn) (context.Context, func() net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var buf bytes.Buffer
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		b := make([]byte, 1024)
+		for buf.Len() < maxPending {
+			n, err := conn.Read(b)
+			buf.Write(b[:n])
+			if err != nil {
+				// timeout is caused by unwatch
+				if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+					cancel()
+				}
+				return
+			}
+		}
+	}()
+
+	go func() {
+		select {
+		case <-p.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() net.Conn {
+		defer cancel()
+
+		conn.SetReadDeadline(time.Unix(1, 0))
+		<-read
+		conn.SetReadDeadline(time.Time{})
+
+		if buf.Len() == 0 {
+			return conn
+		}
+		return &peekedConn{Conn: conn, r: io.MultiReader(&buf, conn)}
+	}
+}
+
+// heldConn releases upstream server connection slot once closed
+type heldConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *heldConn) Close() error {
+	defer c.release()
+	return c.Conn.Close()
+}
@@ -0,0 +1,231 @@
+package stream_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/platform/stream"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+func TestTCP(t *testing.T) {
+	cases := map[string]struct {
+		opts   []stream.TCPOption
+		assert func(*testing.T, *stream.TCP, *upstream.Server, net.Conn)
+	}{
+		"echo": {
+			assert: func(t *testing.T, p *stream.TCP, s *upstream.Server, conn net.Conn) {
+				br := bufio.NewReader(conn)
+				for _, msg := range []string{"foo\n", "bar\n"} {
+					fmt.Fprint(conn, msg)
+					line, err := br.ReadString('\n')
+					assert.Nil(t, err)
+					assert.Equal(t, msg, line)
+				}
+				assert.Equal(t, 1, s.Active())
+
+				conn.Close()
+				time.Sleep(50 * time.Millisecond)
+
+				assert.Equal(t, 0, s.Active())
+				assert.Equal(t, int64(8), p.BytesSent())
+				assert.Equal(t, int64(8), p.BytesReceived())
+			},
+		},
+		"idle timeout": {
+			opts: []stream.TCPOption{stream.WithTCPIdleTimeout(50 * time.Millisecond)},
+			assert: func(t *testing.T, p *stream.TCP, s *upstream.Server, conn net.Conn) {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				_, err := conn.Read(make([]byte, 1))
+				assert.Equal(t, io.EOF, err)
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ul := echoServer(t)
+			defer ul.Close()
+
+			s := runServer(ul.Addr().String())
+			p := stream.NewTCP(balancer.NewRoundRobin([]*upstream.Server{s}), append(c.opts, logger())...)
+
+			conn := serve(t, p)
+			defer p.Stop()
+			defer conn.Close()
+
+			c.assert(t, p, s, conn)
+		})
+	}
+}
+
+func TestTCPUpstreamDown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s := runServer(addr)
+	p := stream.NewTCP(
+		balancer.NewRoundRobin([]*upstream.Server{s}),
+		stream.WithTCPConnectTimeout(100*time.Millisecond),
+		logger(),
+	)
+
+	conn := serve(t, p)
+	defer p.Stop()
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 1, s.Fails())
+	assert.Equal(t, 0, s.Active())
+}
+
+func TestTCPClientLeavesQueue(t *testing.T) {
+	var accepted int32
+	ul := countEchoServer(t, &accepted)
+	defer ul.Close()
+
+	s := upstream.NewServer(
+		ul.Addr().String(),
+		upstream.WithFailTimeout(time.Second),
+		upstream.WithMaxFail(10),
+		upstream.WithMaxConns(1),
+		upstream.WithQueueSize(2),
+	)
+	go s.Run(make(chan struct{}))
+
+	p := stream.NewTCP(balancer.NewRoundRobin([]*upstream.Server{s}), logger())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+	defer p.Stop()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	// first client holds the only upstream connection
+	first := dial()
+	fmt.Fprint(first, "foo\n")
+	line, err := bufio.NewReader(first).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "foo\n", line)
+
+	// second one leaves while queued, third one
+	// sends data which is passed once it is connected
+	second := dial()
+	third := dial()
+	defer third.Close()
+	fmt.Fprint(third, "bar\n")
+	time.Sleep(50 * time.Millisecond)
+	second.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	first.Close()
+
+	third.SetReadDeadline(time.Now().Add(time.Second))
+	line, err = bufio.NewReader(third).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "bar\n", line)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&accepted))
+	assert.Equal(t, 1, s.Active())
+	assert.Equal(t, 0, s.Fails())
+}
+
+func TestTCPStop(t *testing.T) {
+	p := stream.NewTCP(balancer.NewRoundRobin(nil), logger())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- p.Serve(l) }()
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Nil(t, p.Stop())
+
+	select {
+	case err := <-errc:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("stream not stopped")
+	}
+}
+
+func serve(t *testing.T, p *stream.TCP) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func echoServer(t *testing.T) net.Listener {
+	return countEchoServer(t, new(int32))
+}
+
+// countEchoServer counts accepted connections in n
+func countEchoServer(t *testing.T, n *int32) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(n, 1)
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+func runServer(uri string) *upstream.Server {
+	s := upstream.NewServer(
+		uri,
+		upstream.WithFailTimeout(time.Second),
+		upstream.WithMaxFail(10),
+		upstream.WithQueueSize(1),
+	)
+	go s.Run(make(chan struct{}))
+	return s
+}
+
+func logger() stream.TCPOption {
+	return stream.WithTCPLogger(log.New(ioutil.Discard, "", 0))
+}
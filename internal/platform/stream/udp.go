@@ -0,0 +1,249 @@
+package stream
+
+import (
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+// maxDatagramSize is the maximum size of UDP payload
+const maxDatagramSize = 64 * 1024
+
+// NewUDP creates new UDP stream proxy instance
+func NewUDP(bl balancer.Balancer, opts ...UDPOption) *UDP {
+	p := UDP{
+		balancer: bl,
+		sessions: make(map[string]*session),
+		done:     make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(&p.config)
+	}
+
+	if p.config.idleTimeout <= 0 {
+		p.config.idleTimeout = 30 * time.Second
+	}
+
+	if p.config.logger == nil {
+		p.config.logger = log.New(os.Stdout, "udp ", log.Ldate|log.Ltime)
+	}
+
+	return &p
+}
+
+// UDP represents udp datagram proxy. Every client address
+// gets a session bound to a balancer selected upstream server
+// so that replies are routed back to the right client.
+// Sessions expire after idle timeout with no datagrams sent.
+//
+// Passive health checks don't apply to UDP, so server
+// health should be tracked with active health checks.
+type UDP struct {
+	balancer balancer.Balancer
+	config   UDPConfig
+
+	sent     int64
+	received int64
+
+	m        sync.Mutex
+	pc       net.PacketConn
+	sessions map[string]*session
+	wg       sync.WaitGroup
+	done     chan struct{}
+}
+
+// UDPConfig represents udp stream proxy configuration
+type UDPConfig struct {
+	idleTimeout time.Duration
+	logger      *log.Logger
+}
+
+type session struct {
+	client net.Addr
+	server *upstream.Server
+	conn   net.Conn
+	last   int64
+}
+
+// ServePacket proxies datagrams received on pc until the proxy is stopped
+func (p *UDP) ServePacket(pc net.PacketConn) error {
+	p.m.Lock()
+	select {
+	case <-p.done:
+		p.m.Unlock()
+		return nil
+	default:
+		p.pc = pc
+	}
+	p.m.Unlock()
+
+	p.config.logger.Printf("Starting udp stream at: %s", pc.LocalAddr())
+
+	buf := make([]byte, maxDatagramSize)
+
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-p.done:
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		s, err := p.session(addr)
+		if err != nil {
+			p.config.logger.Printf("udp %s: error connecting to upstream: %v", addr, err)
+			continue
+		}
+
+		atomic.StoreInt64(&s.last, time.Now().UnixNano())
+
+		_, err = s.conn.Write(buf[:n])
+		if err != nil {
+			p.config.logger.Printf("udp %s -> %s: %v", addr, s.server.URI(), err)
+			continue
+		}
+
+		atomic.AddInt64(&p.received, int64(n))
+	}
+}
+
+// Stop stops receiving datagrams and closes all sessions
+func (p *UDP) Stop() error {
+	p.m.Lock()
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	var err error
+	if p.pc != nil {
+		err = p.pc.Close()
+	}
+	for _, s := range p.sessions {
+		s.conn.Close()
+	}
+	p.m.Unlock()
+
+	p.wg.Wait()
+
+	return err
+}
+
+// Sessions returns the number of active client sessions
+func (p *UDP) Sessions() int {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return len(p.sessions)
+}
+
+// BytesSent returns the total number of bytes sent to clients
+func (p *UDP) BytesSent() int64 { return atomic.LoadInt64(&p.sent) }
+
+// BytesReceived returns the total number of bytes received from clients
+func (p *UDP) BytesReceived() int64 { return atomic.LoadInt64(&p.received) }
+
+// session returns existing client session or creates
+// a new one with the server selected by the balancer.
+// Sessions with servers which became unavailable are
+// expired so that the client is moved to another server.
+func (p *UDP) session(addr net.Addr) (*session, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if s, ok := p.sessions[addr.String()]; ok {
+		if s.server.Available() {
+			return s, nil
+		}
+		// reply stops once conn is closed
+		// and releases the server
+		delete(p.sessions, addr.String())
+		s.conn.Close()
+	}
+
+	key, _, _ := net.SplitHostPort(addr.String())
+
+	srv, err := p.balancer.NextServer(key)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("udp", srv.URI())
+	if err != nil {
+		return nil, err
+	}
+
+	srv.Acquire()
+
+	s := session{
+		client: addr,
+		server: srv,
+		conn:   conn,
+		last:   time.Now().UnixNano(),
+	}
+	p.sessions[addr.String()] = &s
+
+	p.wg.Add(1)
+	go p.reply(&s)
+
+	return &s, nil
+}
+
+// reply passes upstream replies back to the session client
+// until session has been idle for longer than idle timeout
+func (p *UDP) reply(s *session) {
+	defer p.wg.Done()
+	defer p.expire(s)
+
+	buf := make([]byte, maxDatagramSize)
+
+	for {
+		last := time.Unix(0, atomic.LoadInt64(&s.last))
+		s.conn.SetReadDeadline(last.Add(p.config.idleTimeout))
+
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			ne, ok := err.(net.Error)
+			if !ok || !ne.Timeout() {
+				return
+			}
+			// client may have sent a datagram meanwhile
+			last := time.Unix(0, atomic.LoadInt64(&s.last))
+			if time.Since(last) >= p.config.idleTimeout {
+				return
+			}
+			continue
+		}
+
+		_, err = p.pc.WriteTo(buf[:n], s.client)
+		if err != nil {
+			return
+		}
+
+		atomic.AddInt64(&p.sent, int64(n))
+	}
+}
+
+func (p *UDP) expire(s *session) {
+	p.m.Lock()
+	// session may have already been replaced
+	if p.sessions[s.client.String()] == s {
+		delete(p.sessions, s.client.String())
+	}
+	p.m.Unlock()
+
+	s.conn.Close()
+	s.server.Release()
+}
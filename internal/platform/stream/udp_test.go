@@ -0,0 +1,197 @@
+package stream_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/balancer"
+	"github.com/tonto/gourmet/internal/platform/stream"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+func TestUDP(t *testing.T) {
+	ups := []net.PacketConn{udpEcho(t, "a:"), udpEcho(t, "b:")}
+	defer ups[0].Close()
+	defer ups[1].Close()
+
+	servers := []*upstream.Server{
+		runServer(ups[0].LocalAddr().String()),
+		runServer(ups[1].LocalAddr().String()),
+	}
+
+	p := stream.NewUDP(
+		balancer.NewRoundRobin(servers),
+		stream.WithUDPIdleTimeout(100*time.Millisecond),
+		stream.WithUDPLogger(log.New(ioutil.Discard, "", 0)),
+	)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.ServePacket(pc)
+	defer p.Stop()
+
+	c1 := dialUDP(t, pc.LocalAddr().String())
+	defer c1.Close()
+	c2 := dialUDP(t, pc.LocalAddr().String())
+	defer c2.Close()
+
+	// every client sticks to its session upstream
+	assert.Equal(t, "a:foo", roundTrip(t, c1, "foo"))
+	assert.Equal(t, "b:foo", roundTrip(t, c2, "foo"))
+	assert.Equal(t, "a:bar", roundTrip(t, c1, "bar"))
+	assert.Equal(t, "b:bar", roundTrip(t, c2, "bar"))
+
+	assert.Equal(t, 2, p.Sessions())
+	assert.Equal(t, 1, servers[0].Active())
+	assert.Equal(t, 1, servers[1].Active())
+	assert.Equal(t, int64(12), p.BytesReceived())
+	assert.Equal(t, int64(20), p.BytesSent())
+
+	time.Sleep(300 * time.Millisecond)
+
+	assert.Equal(t, 0, p.Sessions())
+	assert.Equal(t, 0, servers[0].Active())
+	assert.Equal(t, 0, servers[1].Active())
+
+	// expired client gets a new session
+	assert.Equal(t, "a:baz", roundTrip(t, c1, "baz"))
+	assert.Equal(t, 1, p.Sessions())
+}
+
+func TestUDPServerDown(t *testing.T) {
+	ups := []net.PacketConn{udpEcho(t, "a:"), udpEcho(t, "b:")}
+	defer ups[0].Close()
+	defer ups[1].Close()
+
+	probe := &flipProber{}
+
+	a := upstream.NewServer(
+		ups[0].LocalAddr().String(),
+		upstream.WithFailTimeout(time.Second),
+		upstream.WithMaxFail(10),
+		upstream.WithHealthCheck(upstream.HealthCheck{
+			Prober:             probe,
+			Interval:           10 * time.Millisecond,
+			Timeout:            10 * time.Millisecond,
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 1,
+		}),
+	)
+	go a.Run(make(chan struct{}))
+
+	servers := []*upstream.Server{a, runServer(ups[1].LocalAddr().String())}
+
+	p := stream.NewUDP(
+		balancer.NewRoundRobin(servers),
+		stream.WithUDPIdleTimeout(time.Second),
+		stream.WithUDPLogger(log.New(ioutil.Discard, "", 0)),
+	)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.ServePacket(pc)
+	defer p.Stop()
+
+	c := dialUDP(t, pc.LocalAddr().String())
+	defer c.Close()
+
+	assert.Equal(t, "a:foo", roundTrip(t, c, "foo"))
+
+	atomic.StoreInt32(&probe.down, 1)
+	time.Sleep(50 * time.Millisecond)
+
+	// session is moved off the server which went down
+	assert.Equal(t, "b:bar", roundTrip(t, c, "bar"))
+	assert.Equal(t, "b:baz", roundTrip(t, c, "baz"))
+
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 1, p.Sessions())
+	assert.Equal(t, 0, servers[0].Active())
+	assert.Equal(t, 1, servers[1].Active())
+}
+
+func TestUDPStop(t *testing.T) {
+	p := stream.NewUDP(balancer.NewRoundRobin(nil), stream.WithUDPLogger(log.New(ioutil.Discard, "", 0)))
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- p.ServePacket(pc) }()
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Nil(t, p.Stop())
+
+	select {
+	case err := <-errc:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("stream not stopped")
+	}
+}
+
+// flipProber fails probes once down is set
+type flipProber struct {
+	down int32
+}
+
+func (p *flipProber) Probe(context.Context, string) error {
+	if atomic.LoadInt32(&p.down) == 1 {
+		return errors.New("down")
+	}
+	return nil
+}
+
+func udpEcho(t *testing.T, prefix string) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(append([]byte(prefix), buf[:n]...), addr)
+		}
+	}()
+	return pc
+}
+
+func dialUDP(t *testing.T, addr string) net.Conn {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func roundTrip(t *testing.T, conn net.Conn, msg string) string {
+	conn.SetDeadline(time.Now().Add(time.Second))
+	_, err := conn.Write([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
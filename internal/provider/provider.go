@@ -0,0 +1,102 @@
+// Package provider provides a registry of upstream server
+// providers which supply servers of an upstream eg. from
+// static config or service discovery
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+// ErrUnknown is returned when creating servers with
+// a provider name no factory is registered for
+var ErrUnknown = errors.New("unknown upstream server provider")
+
+// ErrNoServers is returned when provider supplies no servers
+var ErrNoServers = errors.New("upstream server provider returned no servers")
+
+// Server represents upstream server listed in config
+// Providers discovering servers may ignore them.
+type Server struct {
+	Path    string
+	Options []upstream.ServerOption
+}
+
+// Factory creates upstream servers, opts apply
+// to all of them (eg. active health checks)
+type Factory func(servers []Server, opts ...upstream.ServerOption) ([]*upstream.Server, error)
+
+var (
+	m         sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+func init() {
+	Register(StaticName, NewStatic)
+}
+
+// Register makes provider factory available under name
+// so that it can be referred to by upstream config.
+// It is meant to be called from init and panics if
+// factory is nil or name is already registered.
+func Register(name string, f Factory) {
+	m.Lock()
+	defer m.Unlock()
+
+	if f == nil {
+		panic("provider: Register factory is nil")
+	}
+	if _, ok := factories[name]; ok {
+		panic("provider: Register called twice for " + name)
+	}
+
+	factories[name] = f
+}
+
+// Registered reports whether factory is registered under name
+func Registered(name string) bool {
+	m.RLock()
+	defer m.RUnlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// Names returns sorted names of registered providers
+func Names() []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	var names []string
+	for n := range factories {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// New creates upstream servers with provider registered under name
+// ErrNoServers is returned if the provider supplies none.
+func New(name string, servers []Server, opts ...upstream.ServerOption) ([]*upstream.Server, error) {
+	m.RLock()
+	f, ok := factories[name]
+	m.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknown, name)
+	}
+
+	us, err := f(servers, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(us) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNoServers, name)
+	}
+
+	return us, nil
+}
@@ -0,0 +1,87 @@
+package provider_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tonto/gourmet/internal/provider"
+	"github.com/tonto/gourmet/internal/upstream"
+)
+
+func TestProvider(t *testing.T) {
+	provider.Register("test_discovery", func([]provider.Server, ...upstream.ServerOption) ([]*upstream.Server, error) {
+		return []*upstream.Server{upstream.NewServer("discovered:80")}, nil
+	})
+	provider.Register("test_empty", func([]provider.Server, ...upstream.ServerOption) ([]*upstream.Server, error) {
+		return nil, nil
+	})
+	t.Cleanup(func() {
+		provider.Unregister("test_discovery")
+		provider.Unregister("test_empty")
+	})
+
+	servers := []provider.Server{
+		{Path: "api1:80", Options: []upstream.ServerOption{upstream.WithWeight(3)}},
+		{Path: "api2:80"},
+	}
+
+	cases := map[string]struct {
+		name      string
+		wantPaths []string
+		wantErr   error
+	}{
+		"static": {
+			name:      provider.StaticName,
+			wantPaths: []string{"api1:80", "api2:80"},
+		},
+		"registered": {
+			name:      "test_discovery",
+			wantPaths: []string{"discovered:80"},
+		},
+		"unknown": {
+			name:    "consul",
+			wantErr: provider.ErrUnknown,
+		},
+		"no servers": {
+			name:    "test_empty",
+			wantErr: provider.ErrNoServers,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			us, err := provider.New(c.name, servers, upstream.WithWeight(1))
+			if c.wantErr != nil {
+				assert.True(t, errors.Is(err, c.wantErr), "%v", err)
+				assert.Equal(t, c.wantErr != provider.ErrUnknown, provider.Registered(c.name))
+				return
+			}
+			assert.Nil(t, err)
+			assert.True(t, provider.Registered(c.name))
+			assert.Contains(t, provider.Names(), c.name)
+
+			var paths []string
+			for _, s := range us {
+				paths = append(paths, s.URI())
+			}
+			assert.Equal(t, c.wantPaths, paths)
+		})
+	}
+
+	assert.Panics(t, func() { provider.Register(provider.StaticName, provider.NewStatic) })
+}
+
+func TestStaticOptions(t *testing.T) {
+	us, err := provider.NewStatic(
+		[]provider.Server{
+			{Path: "api1:80", Options: []upstream.ServerOption{upstream.WithWeight(3)}},
+			{Path: "api2:80"},
+		},
+		upstream.WithWeight(1),
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, us[0].Weight())
+	assert.Equal(t, 1, us[1].Weight())
+}
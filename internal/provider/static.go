@@ -0,0 +1,17 @@
+package provider
+
+import "github.com/tonto/gourmet/internal/upstream"
+
+// StaticName is the name static provider is registered under
+const StaticName = "static"
+
+// NewStatic creates an upstream server for each of servers
+// Server options take precedence over opts.
+func NewStatic(servers []Server, opts ...upstream.ServerOption) ([]*upstream.Server, error) {
+	var us []*upstream.Server
+	for _, s := range servers {
+		so := append(append([]upstream.ServerOption{}, opts...), s.Options...)
+		us = append(us, upstream.NewServer(s.Path, so...))
+	}
+	return us, nil
+}
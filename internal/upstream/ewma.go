@@ -0,0 +1,63 @@
+package upstream
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// latencyDecay represents the time constant of latency moving average
+const latencyDecay = 10 * time.Second
+
+// ewma represents peak exponentially weighted moving average.
+// Samples higher than the current average are taken as is, so
+// latency spikes are reflected immediately and decay over time.
+type ewma struct {
+	m     sync.Mutex
+	value float64
+	stamp time.Time
+
+	// now is used in place of time.Now if set
+	now func() time.Time
+}
+
+func (e *ewma) observe(d time.Duration) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	now := e.clock()
+	v := float64(d)
+
+	if e.stamp.IsZero() || v > e.value {
+		e.value = v
+	} else {
+		w := decay(now.Sub(e.stamp))
+		e.value = e.value*w + v*(1-w)
+	}
+
+	e.stamp = now
+}
+
+// get returns the average decayed for the time elapsed since
+// the last sample, so that an idle server is tried again
+func (e *ewma) get() time.Duration {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if e.stamp.IsZero() {
+		return 0
+	}
+
+	return time.Duration(e.value * decay(e.clock().Sub(e.stamp)))
+}
+
+func (e *ewma) clock() time.Time {
+	if e.now != nil {
+		return e.now()
+	}
+	return time.Now()
+}
+
+func decay(elapsed time.Duration) float64 {
+	return math.Exp(-float64(elapsed) / float64(latencyDecay))
+}
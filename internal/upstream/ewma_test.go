@@ -0,0 +1,37 @@
+package upstream
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEWMA(t *testing.T) {
+	now := time.Now()
+	e := ewma{now: func() time.Time { return now }}
+
+	assert.Equal(t, time.Duration(0), e.get())
+
+	e.observe(100 * time.Millisecond)
+	assert.Equal(t, 100*time.Millisecond, e.get())
+
+	// peaks are taken as is
+	e.observe(200 * time.Millisecond)
+	assert.Equal(t, 200*time.Millisecond, e.get())
+
+	// average decays while the server is idle
+	now = now.Add(latencyDecay)
+	assert.InDelta(t, float64(200*time.Millisecond)/math.E, float64(e.get()), float64(time.Millisecond))
+
+	now = now.Add(10 * latencyDecay)
+	assert.True(t, e.get() < time.Millisecond)
+
+	// lower samples are averaged in
+	e = ewma{now: func() time.Time { return now }}
+	e.observe(200 * time.Millisecond)
+	now = now.Add(latencyDecay)
+	e.observe(100 * time.Millisecond)
+	assert.InDelta(t, float64(200*time.Millisecond)/math.E+float64(100*time.Millisecond)*(1-1/math.E), float64(e.get()), float64(time.Millisecond))
+}
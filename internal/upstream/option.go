@@ -31,3 +31,29 @@ func WithQueueSize(n int) ServerOption {
 		sc.queueBufferSz = n
 	}
 }
+
+// WithQueueTimeout sets max time a request may wait in
+// upstream server queue before it is dropped
+func WithQueueTimeout(d time.Duration) ServerOption {
+	return func(sc *ServerConfig) {
+		sc.queueTimeout = d
+	}
+}
+
+// WithMaxConns sets the number of connections upstream
+// server handles in parallel, including responses which
+// are still being read (default 1)
+func WithMaxConns(n int) ServerOption {
+	return func(sc *ServerConfig) {
+		if n > 0 {
+			sc.maxConns = n
+		}
+	}
+}
+
+// WithHealthCheck enables active health checks of upstream server
+func WithHealthCheck(hc HealthCheck) ServerOption {
+	return func(sc *ServerConfig) {
+		sc.healthCheck = &hc
+	}
+}
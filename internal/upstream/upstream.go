@@ -4,6 +4,7 @@ package upstream
 import (
 	"context"
 	"errors"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -14,12 +15,56 @@ var (
 
 	// ErrPassiveHealthCheck represents passive health check fail
 	ErrPassiveHealthCheck = errors.New("request failed passive health check timeout")
+
+	// ErrQueueFull is returned by Enqueue when server request queue is full
+	ErrQueueFull = errors.New("upstream server request queue is full")
+
+	// ErrQueueTimeout is sent to Done when a request waited
+	// in the queue for longer than queue timeout
+	ErrQueueTimeout = errors.New("request timed out waiting in upstream server queue")
+
+	// errAbandoned is returned by requests whose caller
+	// stopped waiting before they were dequeued
+	errAbandoned = errors.New("request abandoned while queued")
+)
+
+// Request states used by Do
+const (
+	requestQueued int32 = iota
+	requestStarted
+	requestAbandoned
 )
 
 // Request represents upstream request
 type Request struct {
 	F    func(context.Context, string) error
 	Done chan error
+
+	// Ctx is optional request context. Requests whose context
+	// is done by the time they are dequeued are dropped.
+	Ctx context.Context
+
+	queued time.Time
+
+	// hold keeps connection slot taken by a successful
+	// request until released by the caller of Do
+	hold bool
+}
+
+// Prober represents active health check probe
+type Prober interface {
+	// Probe should return a non nil error if
+	// the server at uri is considered unhealthy
+	Probe(ctx context.Context, uri string) error
+}
+
+// HealthCheck represents active health check configuration
+type HealthCheck struct {
+	Prober             Prober
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
 }
 
 // ServerConfig represents upstream server configuration
@@ -28,12 +73,17 @@ type ServerConfig struct {
 	maxFail       int
 	failTimeout   time.Duration
 	queueBufferSz int
+	queueTimeout  time.Duration
+	maxConns      int
+	healthCheck   *HealthCheck
 }
 
 // NewServer creates new upstream server instance
 // and starts queue handler
 func NewServer(uri string, opts ...ServerOption) *Server {
-	cfg := ServerConfig{}
+	cfg := ServerConfig{
+		maxConns: 1,
+	}
 
 	for _, o := range opts {
 		o(&cfg)
@@ -44,6 +94,7 @@ func NewServer(uri string, opts ...ServerOption) *Server {
 		Work:      make(chan Request, cfg.queueBufferSz),
 		uri:       uri,
 		config:    cfg,
+		slots:     make(chan struct{}, cfg.maxConns),
 	}
 
 	return &h
@@ -55,8 +106,15 @@ type Server struct {
 	Work      chan Request
 	uri       string
 	currFail  int32
+	fails     int64
+	active    int32
+	latency   ewma
 	config    ServerConfig
 	available uint32
+
+	// slots bounds connections to the server to
+	// max conns, including responses still being read
+	slots chan struct{}
 }
 
 // Available returns a bool indicating wether
@@ -69,33 +127,245 @@ func (s *Server) Available() bool {
 // Weight returns weight assigned to upstream server
 func (s *Server) Weight() int { return s.config.weight }
 
+// MaxFail returns max fail option of upstream server
+func (s *Server) MaxFail() int { return s.config.maxFail }
+
+// Fails returns total number of failed requests
+// since the server was started
+func (s *Server) Fails() int { return int(atomic.LoadInt64(&s.fails)) }
+
+// Latency returns peak ewma of request latency
+// It is zero until the first request is processed
+func (s *Server) Latency() time.Duration { return s.latency.get() }
+
+// Fail records a failed request which is accounted for by
+// passive health checks. It is used for failures observed
+// after the request has been processed, eg. errors reported
+// by upstream in response trailers.
+func (s *Server) Fail() {
+	atomic.AddInt32(&s.currFail, 1)
+	atomic.AddInt64(&s.fails, 1)
+}
+
+// URI returns upstream server uri
+func (s *Server) URI() string { return s.uri }
+
+// Acquire marks a request as active on the server
+// It should be called before the request is sent to Work
+func (s *Server) Acquire() { atomic.AddInt32(&s.active, 1) }
+
+// Release marks a previously acquired request as done
+func (s *Server) Release() { atomic.AddInt32(&s.active, -1) }
+
+// Active returns the number of requests currently
+// enqueued or being processed by the server
+func (s *Server) Active() int { return int(atomic.LoadInt32(&s.active)) }
+
+// Enqueue places r on server request queue without blocking
+// ErrQueueFull is returned if the queue is at capacity
+func (s *Server) Enqueue(r Request) error {
+	r.queued = time.Now()
+
+	select {
+	case s.Work <- r:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Do enqueues f and waits for it to be processed
+// Unlike Enqueue it stops waiting as soon as the request
+// has been queued for longer than queue timeout or ctx is
+// done, without waiting for a worker to dequeue it.
+// Requests which have already started are waited for.
+//
+// If f succeeds the connection slot it took is held until
+// release is called, so that max conns also bounds connections
+// outliving f eg. response bodies or proxied streams.
+func (s *Server) Do(ctx context.Context, f func(context.Context, string) error) (release func(), err error) {
+	state := requestQueued
+
+	// done is buffered so that a worker never
+	// blocks on a request which was abandoned
+	done := make(chan error, 1)
+
+	err = s.Enqueue(Request{
+		Ctx:  ctx,
+		Done: done,
+		hold: true,
+		F: func(c context.Context, uri string) error {
+			if !atomic.CompareAndSwapInt32(&state, requestQueued, requestStarted) {
+				return errAbandoned
+			}
+			return f(c, uri)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var timeout <-chan time.Time
+	if s.config.queueTimeout > 0 {
+		t := time.NewTimer(s.config.queueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case err = <-done:
+		return s.held(err)
+	case <-timeout:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if atomic.CompareAndSwapInt32(&state, requestQueued, requestAbandoned) {
+		return nil, err
+	}
+
+	return s.held(<-done)
+}
+
+// held returns release func of a slot held by request
+// which finished with err, slots of failed ones are
+// released by workers
+func (s *Server) held(err error) (func(), error) {
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-s.slots }) }, nil
+}
+
 // Run runs a server
 // It is designed to be run async and closed by sending to c chan
+//
+// Requests from Work are processed by max conns workers in parallel.
+// If active health checks are enabled, a server marked as
+// unavailable by passive checks is restored only by a successful probe.
 func (s *Server) Run(c chan struct{}) {
 	ticker := time.NewTicker(s.config.failTimeout)
 	defer ticker.Stop()
 
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if s.config.healthCheck != nil {
+		go s.check(stop)
+	}
+
+	for i := 0; i < s.config.maxConns; i++ {
+		go s.work(stop)
+	}
+
 	for {
 		select {
 		case <-ticker.C:
-			if s.currFail >= int32(s.config.maxFail) {
+			if atomic.LoadInt32(&s.currFail) >= int32(s.config.maxFail) {
 				atomic.StoreUint32(&s.available, 0)
-			} else {
+			} else if s.config.healthCheck == nil {
 				atomic.StoreUint32(&s.available, 1)
 			}
 			atomic.StoreInt32(&s.currFail, 0)
+		case <-c:
+			return
+		}
+	}
+}
+
+func (s *Server) work(stop chan struct{}) {
+	for {
+		select {
 		case r := <-s.Work:
-			// This timeout should probably be a lot shorter and configurable
-			ctx, cancel := context.WithTimeout(context.Background(), s.config.failTimeout)
-			defer cancel()
+			// a connection slot is taken before the request is
+			// processed, it remains queued until one is freed
+			select {
+			case s.slots <- struct{}{}:
+			case <-stop:
+				return
+			}
+			s.process(r)
+		case <-stop:
+			return
+		}
+	}
+}
 
-			err := r.F(ctx, s.uri)
-			if err != nil {
-				atomic.AddInt32(&s.currFail, 1)
+func (s *Server) process(r Request) {
+	var err error
+	defer func() {
+		if err != nil || !r.hold {
+			<-s.slots
+		}
+	}()
+
+	if r.Ctx != nil && r.Ctx.Err() != nil {
+		err = r.Ctx.Err()
+		r.Done <- err
+		return
+	}
+
+	if s.config.queueTimeout > 0 &&
+		!r.queued.IsZero() &&
+		time.Since(r.queued) > s.config.queueTimeout {
+		err = ErrQueueTimeout
+		r.Done <- err
+		return
+	}
+
+	// fail timeout only sets the window failures are counted in,
+	// request time limits are up to the protocol passing it
+	ctx := r.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	t := time.Now()
+	err = r.F(ctx, s.uri)
+	if err == errAbandoned {
+		r.Done <- err
+		return
+	}
+	s.latency.observe(time.Since(t))
+	if err != nil {
+		s.Fail()
+	}
+
+	r.Done <- err
+}
+
+func (s *Server) check(stop chan struct{}) {
+	hc := s.config.healthCheck
+
+	ticker := time.NewTicker(hc.Interval)
+	defer ticker.Stop()
+
+	var healthy, unhealthy int
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), hc.Timeout)
+		err := hc.Prober.Probe(ctx, s.uri)
+		cancel()
+
+		if err != nil {
+			healthy = 0
+			unhealthy++
+			if unhealthy >= hc.UnhealthyThreshold {
+				atomic.StoreUint32(&s.available, 0)
+			}
+		} else {
+			unhealthy = 0
+			healthy++
+			if healthy >= hc.HealthyThreshold {
+				atomic.StoreUint32(&s.available, 1)
 			}
+		}
 
-			r.Done <- err
-		case <-c:
+		select {
+		case <-ticker.C:
+		case <-stop:
 			return
 		}
 	}
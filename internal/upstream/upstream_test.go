@@ -3,6 +3,8 @@ package upstream
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -89,7 +91,9 @@ func TestServerHealth(t *testing.T) {
 			weight:               3,
 			buffSz:               10,
 			expectedAvailability: false,
-			wait:                 1 * time.Second,
+			// availability is updated on fail timeout tick,
+			// sampling right at it races the ticker
+			wait: 1200 * time.Millisecond,
 			req: func(it int) *Request {
 				return &Request{
 					F: func(context.Context, string) error {
@@ -153,3 +157,402 @@ func TestServerHealth(t *testing.T) {
 		})
 	}
 }
+
+func TestServerLatency(t *testing.T) {
+	srv := NewServer("foo.com", WithFailTimeout(time.Second))
+
+	cc := make(chan struct{})
+	go srv.Run(cc)
+	defer func() { cc <- struct{}{} }()
+
+	assert.Equal(t, time.Duration(0), srv.Latency())
+
+	send := func(d time.Duration) {
+		done := make(chan error)
+		srv.Work <- Request{
+			F: func(context.Context, string) error {
+				time.Sleep(d)
+				return nil
+			},
+			Done: done,
+		}
+		<-done
+	}
+
+	send(20 * time.Millisecond)
+	assert.True(t, srv.Latency() >= 20*time.Millisecond)
+
+	peak := srv.Latency()
+	send(time.Millisecond)
+	assert.True(t, srv.Latency() < peak)
+	assert.True(t, srv.Latency() > time.Millisecond)
+
+	send(50 * time.Millisecond)
+	assert.True(t, srv.Latency() >= 50*time.Millisecond)
+}
+
+func TestServerActiveHealthCheck(t *testing.T) {
+	cases := map[string]struct {
+		probes               []error
+		passiveFail          bool
+		wait                 time.Duration
+		expectedAvailability bool
+	}{
+		"healthy": {
+			probes:               []error{nil, nil, nil},
+			wait:                 50 * time.Millisecond,
+			expectedAvailability: true,
+		},
+		"unhealthy threshold": {
+			probes:               []error{fmt.Errorf("down"), fmt.Errorf("down"), fmt.Errorf("down")},
+			wait:                 50 * time.Millisecond,
+			expectedAvailability: false,
+		},
+		"below unhealthy threshold": {
+			probes:               []error{fmt.Errorf("down"), nil, fmt.Errorf("down"), nil},
+			wait:                 50 * time.Millisecond,
+			expectedAvailability: true,
+		},
+		"regain health": {
+			probes:               []error{fmt.Errorf("down"), fmt.Errorf("down"), nil, nil},
+			wait:                 50 * time.Millisecond,
+			expectedAvailability: true,
+		},
+		"passive fail not restored by tick": {
+			probes:               []error{fmt.Errorf("down")},
+			passiveFail:          true,
+			wait:                 150 * time.Millisecond,
+			expectedAvailability: false,
+		},
+		"passive fail restored by probe": {
+			probes:               []error{nil},
+			passiveFail:          true,
+			wait:                 150 * time.Millisecond,
+			expectedAvailability: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			p := &prober{results: c.probes}
+			interval := 5 * time.Millisecond
+			if c.passiveFail {
+				interval = 100 * time.Millisecond
+			}
+
+			srv := NewServer(
+				"foo.com",
+				WithFailTimeout(20*time.Millisecond),
+				WithMaxFail(1),
+				WithHealthCheck(HealthCheck{
+					Prober:             p,
+					Interval:           interval,
+					Timeout:            time.Second,
+					HealthyThreshold:   1,
+					UnhealthyThreshold: 2,
+				}),
+			)
+
+			cc := make(chan struct{})
+			go srv.Run(cc)
+
+			if c.passiveFail {
+				done := make(chan error)
+				srv.Work <- Request{
+					F: func(context.Context, string) error {
+						return fmt.Errorf("some upstream err")
+					},
+					Done: done,
+				}
+				<-done
+				time.Sleep(30 * time.Millisecond)
+				assert.False(t, srv.Available())
+			}
+
+			time.Sleep(c.wait)
+			assert.Equal(t, c.expectedAvailability, srv.Available())
+
+			cc <- struct{}{}
+		})
+	}
+}
+
+type prober struct {
+	m       sync.Mutex
+	results []error
+	n       int
+}
+
+// Probe returns results in order repeating the last one
+func (p *prober) Probe(context.Context, string) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	i := p.n
+	if i >= len(p.results) {
+		i = len(p.results) - 1
+	}
+	p.n++
+
+	return p.results[i]
+}
+
+func TestServerMaxConns(t *testing.T) {
+	cases := map[string]struct {
+		maxConns    int
+		numReqs     int
+		wantRunning int32
+	}{
+		"default":       {numReqs: 5, wantRunning: 1},
+		"parallel":      {maxConns: 4, numReqs: 4, wantRunning: 4},
+		"queued":        {maxConns: 3, numReqs: 6, wantRunning: 3},
+		"invalid value": {maxConns: -1, numReqs: 3, wantRunning: 1},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := NewServer(
+				"foo.com",
+				WithFailTimeout(time.Second),
+				WithMaxFail(100),
+				WithQueueSize(c.numReqs),
+				WithMaxConns(c.maxConns),
+			)
+
+			cc := make(chan struct{})
+			go srv.Run(cc)
+
+			var running int32
+			release := make(chan struct{})
+			done := make(chan error, c.numReqs)
+
+			for i := 0; i < c.numReqs; i++ {
+				srv.Work <- Request{
+					F: func(context.Context, string) error {
+						atomic.AddInt32(&running, 1)
+						<-release
+						return fmt.Errorf("some upstream err")
+					},
+					Done: done,
+				}
+			}
+
+			time.Sleep(50 * time.Millisecond)
+			assert.Equal(t, c.wantRunning, atomic.LoadInt32(&running))
+
+			close(release)
+			for i := 0; i < c.numReqs; i++ {
+				assert.Error(t, <-done)
+			}
+			assert.Equal(t, c.numReqs, srv.Fails())
+
+			cc <- struct{}{}
+		})
+	}
+}
+
+func TestServerEnqueue(t *testing.T) {
+	cases := map[string]struct {
+		queueSz      int
+		queueTimeout time.Duration
+		req          func(context.Context, context.CancelFunc) Request
+		wantErr      error
+		wantDoneErr  error
+		wantExecuted bool
+	}{
+		"enqueued": {
+			queueSz: 1,
+			req: func(ctx context.Context, _ context.CancelFunc) Request {
+				return Request{Ctx: ctx}
+			},
+			wantExecuted: true,
+		},
+		"queue full": {
+			req: func(ctx context.Context, _ context.CancelFunc) Request {
+				return Request{Ctx: ctx}
+			},
+			wantErr: ErrQueueFull,
+		},
+		"cancelled while queued": {
+			queueSz: 1,
+			req: func(ctx context.Context, cancel context.CancelFunc) Request {
+				cancel()
+				return Request{Ctx: ctx}
+			},
+			wantDoneErr: context.Canceled,
+		},
+		"queue timeout": {
+			queueSz:      1,
+			queueTimeout: 10 * time.Millisecond,
+			req: func(ctx context.Context, _ context.CancelFunc) Request {
+				return Request{Ctx: ctx}
+			},
+			wantDoneErr: ErrQueueTimeout,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := NewServer(
+				"foo.com",
+				WithFailTimeout(time.Second),
+				WithMaxFail(10),
+				WithQueueSize(c.queueSz),
+				WithQueueTimeout(c.queueTimeout),
+			)
+
+			cc := make(chan struct{})
+			go srv.Run(cc)
+			defer func() { cc <- struct{}{} }()
+
+			// occupy the only worker so requests stay queued
+			release := make(chan struct{})
+			busy := make(chan error, 1)
+			srv.Work <- Request{
+				F: func(context.Context, string) error {
+					<-release
+					return nil
+				},
+				Done: busy,
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var executed bool
+			r := c.req(ctx, cancel)
+			r.Done = make(chan error, 1)
+			r.F = func(context.Context, string) error {
+				executed = true
+				return nil
+			}
+
+			err := srv.Enqueue(r)
+			assert.Equal(t, c.wantErr, err)
+
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			<-busy
+
+			if err == nil {
+				assert.Equal(t, c.wantDoneErr, <-r.Done)
+			}
+			assert.Equal(t, c.wantExecuted, executed)
+			assert.Equal(t, 0, srv.Fails())
+		})
+	}
+}
+
+func TestServerDo(t *testing.T) {
+	cases := map[string]struct {
+		queueTimeout time.Duration
+		cancel       bool
+		wantErr      error
+	}{
+		"queue timeout": {
+			queueTimeout: 10 * time.Millisecond,
+			wantErr:      ErrQueueTimeout,
+		},
+		"cancelled while queued": {
+			cancel:  true,
+			wantErr: context.Canceled,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			srv := NewServer(
+				"foo.com",
+				WithFailTimeout(time.Second),
+				WithMaxFail(10),
+				WithQueueSize(1),
+				WithQueueTimeout(c.queueTimeout),
+			)
+
+			cc := make(chan struct{})
+			go srv.Run(cc)
+			defer func() { cc <- struct{}{} }()
+
+			// occupy the only worker so the request stays queued
+			release := make(chan struct{})
+			busy := make(chan error, 1)
+			srv.Work <- Request{
+				F: func(context.Context, string) error {
+					<-release
+					return nil
+				},
+				Done: busy,
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if c.cancel {
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+
+			var executed int32
+			_, err := srv.Do(ctx, func(context.Context, string) error {
+				atomic.StoreInt32(&executed, 1)
+				return nil
+			})
+
+			// Do returns while the worker is still busy
+			select {
+			case <-busy:
+				t.Fatal("worker done before Do returned")
+			default:
+			}
+			assert.Equal(t, c.wantErr, err)
+
+			close(release)
+			<-busy
+			time.Sleep(10 * time.Millisecond)
+
+			assert.Equal(t, int32(0), atomic.LoadInt32(&executed))
+			assert.Equal(t, 0, srv.Fails())
+		})
+	}
+}
+
+func TestServerDoHoldsConn(t *testing.T) {
+	srv := NewServer(
+		"foo.com",
+		WithFailTimeout(time.Second),
+		WithMaxFail(10),
+		WithMaxConns(1),
+		WithQueueSize(1),
+		WithQueueTimeout(20*time.Millisecond),
+	)
+
+	cc := make(chan struct{})
+	go srv.Run(cc)
+	defer func() { cc <- struct{}{} }()
+
+	f := func(context.Context, string) error { return nil }
+
+	release, err := srv.Do(context.Background(), f)
+	assert.Nil(t, err)
+
+	// the only slot is held until released
+	_, err = srv.Do(context.Background(), f)
+	assert.Equal(t, ErrQueueTimeout, err)
+
+	release()
+	release()
+
+	release, err = srv.Do(context.Background(), f)
+	assert.Nil(t, err)
+	release()
+
+	// failed requests release the slot
+	_, err = srv.Do(context.Background(), func(context.Context, string) error {
+		return fmt.Errorf("foo")
+	})
+	assert.NotNil(t, err)
+
+	release, err = srv.Do(context.Background(), f)
+	assert.Nil(t, err)
+	release()
+}